@@ -6,31 +6,50 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	// auth reads its secrets and settings from the environment when it is initialized,
+	// which can happen before the database package loads the .env file
+	_ "github.com/joho/godotenv/autoload"
 )
 
 var (
 	jwtAccessSecretKey = []byte(os.Getenv("JWT_ACCESS_SECRET"))
 	jwtRefreshSecretKey = []byte(os.Getenv("JWT_REFRESH_SECRET"))
+	jwtChallengeSecretKey = []byte(os.Getenv("JWT_CHALLENGE_SECRET"))
+	jwtVoterSecretKey = []byte(os.Getenv("JWT_VOTER_SECRET"))
+	jwtGrantSecretKey = []byte(os.Getenv("JWT_GRANT_SECRET"))
 )
 
 type TokenType int
 const (	
 	TokenTypeAccess = iota
 	TokenTypeRefresh
+	TokenTypeChallenge
+	TokenTypeVoter
+	TokenTypePollGrant
 )
 
+// Claims are carried by access and refresh tokens. The role lets middleware
+// authorize a request without looking the user up
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	jwt.StandardClaims
+}
+
 type TokenPair struct {
 	AccessToken  string
 	RefreshToken string
 }
 
-func GenerateTokens(userId string) (TokenPair, error) {
+func GenerateTokens(userId string, role string) (TokenPair, error) {
 	var err error
 	tokens := TokenPair{}
 
-	accessClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer: userId,
-		ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+	accessClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		Role: role,
+		StandardClaims: jwt.StandardClaims{
+			Issuer: userId,
+			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+		},
 	})
 
 	tokens.AccessToken, err = accessClaims.SignedString(jwtAccessSecretKey)
@@ -38,9 +57,12 @@ func GenerateTokens(userId string) (TokenPair, error) {
 		return TokenPair{}, err
 	}
 
-	refreshClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Issuer: userId,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7).Unix(),
+	refreshClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		Role: role,
+		StandardClaims: jwt.StandardClaims{
+			Issuer: userId,
+			ExpiresAt: time.Now().Add(time.Hour * 24 * 7).Unix(),
+		},
 	})
 
 	tokens.RefreshToken, err = refreshClaims.SignedString(jwtRefreshSecretKey)
@@ -51,14 +73,66 @@ func GenerateTokens(userId string) (TokenPair, error) {
 	return tokens, nil
 }
 
-func ParseToken(tokenString string, tokenType TokenType) (*jwt.StandardClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, 
+// GenerateChallengeToken issues the short-lived token a user holds between entering their
+// password and entering their second factor
+func GenerateChallengeToken(userId string) (string, error) {
+	challengeClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Issuer: userId,
+		ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+	})
+	return challengeClaims.SignedString(jwtChallengeSecretKey)
+}
+
+// ReactivationAudience sets reactivation tokens apart from the challenge tokens they share a
+// secret with
+const ReactivationAudience = "reactivation"
+
+// GenerateReactivationToken issues the short-lived token a deactivated user gets once they
+// have fully logged in, which they trade for reactivating their account
+func GenerateReactivationToken(userId string) (string, error) {
+	reactivationClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Issuer: userId,
+		Audience: ReactivationAudience,
+		ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+	})
+	return reactivationClaims.SignedString(jwtChallengeSecretKey)
+}
+
+// GenerateVoterToken issues the token that identifies an anonymous voter's device. The device
+// ID is carried in the subject, since the token doesn't belong to any user
+func GenerateVoterToken(deviceId string) (string, error) {
+	voterClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Subject: deviceId,
+		IssuedAt: time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour * 24 * 365).Unix(),
+	})
+	return voterClaims.SignedString(jwtVoterSecretKey)
+}
+
+// GeneratePollGrant issues the token that proves its holder knows a protected poll's
+// passphrase. The poll ID is carried in the subject
+func GeneratePollGrant(pollId string, lifetime time.Duration) (string, error) {
+	grantClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Subject: pollId,
+		ExpiresAt: time.Now().Add(lifetime).Unix(),
+	})
+	return grantClaims.SignedString(jwtGrantSecretKey)
+}
+
+func ParseToken(tokenString string, tokenType TokenType) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, 
 	func(token *jwt.Token) (interface{}, error) {
 		switch (tokenType) {
 		case TokenTypeAccess:
 			return jwtAccessSecretKey, nil
 		case TokenTypeRefresh:
 			return jwtRefreshSecretKey, nil
+		case TokenTypeChallenge:
+			return jwtChallengeSecretKey, nil
+		case TokenTypeVoter:
+			return jwtVoterSecretKey, nil
+		case TokenTypePollGrant:
+			return jwtGrantSecretKey, nil
 		default:
 			return nil, errors.New("couldn't parse token due to unknown TokenType")
 		}
@@ -68,6 +142,6 @@ func ParseToken(tokenString string, tokenType TokenType) (*jwt.StandardClaims, e
 		return nil, err 
 	}
 
-	claims := token.Claims.(*jwt.StandardClaims)
+	claims := token.Claims.(*Claims)
 	return claims, nil
 }
@@ -0,0 +1,410 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"rapidvote/api/util"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const pkceVerifierLength uint = 64
+
+var (
+	jwtStateSecretKey = []byte(os.Getenv("JWT_STATE_SECRET"))
+
+	// OIDCProviders holds every provider listed in `OIDC_PROVIDERS`, keyed by its name
+	OIDCProviders = loadOIDCProviders()
+)
+
+// OIDCProvider is a single OpenID Connect identity provider. Every provider is configured
+// through environment variables prefixed with `OIDC_<NAME>_`, e.g. for `OIDC_PROVIDERS=corp`:
+//
+//	OIDC_CORP_ISSUER, OIDC_CORP_CLIENT_ID, OIDC_CORP_CLIENT_SECRET,
+//	OIDC_CORP_REDIRECT_URL, OIDC_CORP_SCOPES, OIDC_CORP_LINK_BY_EMAIL
+//
+// The issuer may be a plain http:// URL so a local mock OIDC server can be used in development.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// LinkByEmail lets a first login link to an existing account with the same verified email
+	LinkByEmail bool
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// IDTokenClaims are the ID token claims RapidVote cares about
+type IDTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// VerifiedEmail is the email, if the provider says it verified it. An unverified email could
+// belong to anyone, so it must never match or be stored on an account
+func (c *IDTokenClaims) VerifiedEmail() string {
+	if !c.EmailVerified {
+		return ""
+	}
+	return c.Email
+}
+
+// OIDCStateClaims are stored in a short-lived cookie between the login redirect and the callback
+type OIDCStateClaims struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+func loadOIDCProviders() map[string]*OIDCProvider {
+	providers := make(map[string]*OIDCProvider)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		scopes := []string{"openid", "email", "profile"}
+		if s := os.Getenv(prefix + "SCOPES"); s != "" {
+			scopes = strings.Fields(strings.ReplaceAll(s, ",", " "))
+		}
+
+		providers[strings.ToLower(name)] = &OIDCProvider{
+			Name:         strings.ToLower(name),
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       scopes,
+			LinkByEmail:  os.Getenv(prefix+"LINK_BY_EMAIL") == "true",
+		}
+	}
+	return providers
+}
+
+// GenerateOIDCState creates the state, nonce and PKCE verifier for a new authorization request,
+// and signs them into a token that is kept by the client until the callback
+func GenerateOIDCState(provider string) (string, OIDCStateClaims, error) {
+	claims := OIDCStateClaims{
+		Provider: provider,
+		Nonce:    util.GenSecureRandomString(32),
+		Verifier: util.GenSecureRandomString(pkceVerifierLength),
+		StandardClaims: jwt.StandardClaims{
+			Id:        util.GenSecureRandomString(32),
+			ExpiresAt: time.Now().Add(time.Minute * 10).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtStateSecretKey)
+	if err != nil {
+		return "", OIDCStateClaims{}, err
+	}
+	return token, claims, nil
+}
+
+func ParseOIDCState(tokenString string) (*OIDCStateClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &OIDCStateClaims{},
+	func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method for OIDC state")
+		}
+		return jwtStateSecretKey, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*OIDCStateClaims)
+	return claims, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL builds the URL the user is sent to in order to sign in with the provider
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state OIDCStateClaims) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state.Id)
+	params.Set("nonce", state.Nonce)
+	params.Set("code_challenge", pkceChallenge(state.Verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens at the provider's token endpoint,
+// and returns the raw ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("couldn't decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response didn't contain an id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's JWKS, along with
+// its issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*IDTokenClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	token, err := parser.ParseWithClaims(rawIDToken, &IDTokenClaims{},
+	func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, discovery, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*IDTokenClaims)
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, fmt.Errorf("unexpected ID token issuer %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("ID token wasn't issued for this client")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("couldn't discover OIDC provider %s: %w", p.Name, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovered issuer %q doesn't match configured issuer %q", discovery.Issuer, p.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document for OIDC provider %s is incomplete", p.Name)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// publicKey returns the signing key with the given ID, refetching the JWKS when the
+// key isn't known yet so that provider key rotation is picked up
+func (p *OIDCProvider) publicKey(ctx context.Context, discovery *oidcDiscovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("couldn't fetch JWKS: %w", err)
+	}
+
+	p.keys = make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key found with kid %q", kid)
+}
+
+func (p *OIDCProvider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	mockClientID     = "rapidvote"
+	mockClientSecret = "mock-secret"
+	mockKeyID        = "mock-key"
+)
+
+// mockGrant is what the mock IdP remembers about an authorization request until the code
+// is exchanged
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+// mockIdP is a minimal OpenID Connect provider: discovery, an authorization endpoint that
+// approves every request right away, a token endpoint checking PKCE, and a JWKS
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+	// claims lets a test change the ID token before it is signed
+	claims func(*IDTokenClaims)
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kid: mockKeyID,
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:         "mock",
+		Issuer:       idp.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		RedirectURL:  "http://localhost:3000/oidc/mock/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// authorize redirects straight back with a code, the way a provider does once the user signed in
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code := "code-" + query.Get("state")
+	idp.mu.Lock()
+	idp.grants[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(reason string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": reason})
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != mockClientID || clientSecret != mockClientSecret {
+		fail("bad client credentials")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("bad token request")
+		return
+	}
+
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok {
+		fail("unknown code")
+		return
+	}
+	if pkceChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		fail("PKCE verifier doesn't match")
+		return
+	}
+
+	claims := IDTokenClaims{
+		Email:         "voter@example.com",
+		EmailVerified: true,
+		Nonce:         grant.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.server.URL,
+			Subject:   "mock-subject",
+			Audience:  jwt.ClaimStrings{mockClientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	if idp.claims != nil {
+		idp.claims(&claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockKeyID
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// login runs the flow up to the callback: starting it, visiting the authorization URL and
+// reading the code off the redirect, then exchanging it and verifying the ID token
+func login(t *testing.T, idp *mockIdP, provider *OIDCProvider, verifier func(OIDCStateClaims) string) (*IDTokenClaims, error) {
+	ctx := context.Background()
+
+	stateToken, state, err := GenerateOIDCState(provider.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint returned %d", resp.StatusCode)
+	}
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What OIDCCallback checks before exchanging the code
+	parsed, err := ParseOIDCState(stateToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Id != redirect.Query().Get("state") || parsed.Provider != provider.Name {
+		t.Fatal("state doesn't survive the round trip")
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, redirect.Query().Get("code"), verifier(*parsed))
+	if err != nil {
+		return nil, err
+	}
+	return provider.VerifyIDToken(ctx, rawIDToken, parsed.Nonce)
+}
+
+func stateVerifier(state OIDCStateClaims) string {
+	return state.Verifier
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+
+	claims, err := login(t, idp, idp.provider(), stateVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "mock-subject" {
+		t.Errorf("subject = %q, want mock-subject", claims.Subject)
+	}
+	if claims.VerifiedEmail() != "voter@example.com" {
+		t.Errorf("verified email = %q, want voter@example.com", claims.VerifiedEmail())
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = func(claims *IDTokenClaims) {
+		claims.EmailVerified = false
+	}
+
+	claims, err := login(t, idp, idp.provider(), stateVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "voter@example.com" {
+		t.Errorf("email = %q, want voter@example.com", claims.Email)
+	}
+	if claims.VerifiedEmail() != "" {
+		t.Errorf("unverified email %q was used", claims.VerifiedEmail())
+	}
+}
+
+func TestOIDCWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+
+	_, err := login(t, idp, idp.provider(), func(OIDCStateClaims) string {
+		return "not-the-verifier"
+	})
+	if err == nil {
+		t.Fatal("code was exchanged without the PKCE verifier")
+	}
+}
+
+func TestOIDCRejectsIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(*IDTokenClaims)
+	}{
+		{"wrong nonce", func(claims *IDTokenClaims) { claims.Nonce = "replayed" }},
+		{"wrong audience", func(claims *IDTokenClaims) { claims.Audience = jwt.ClaimStrings{"someone-else"} }},
+		{"wrong issuer", func(claims *IDTokenClaims) { claims.Issuer = "https://evil.example.com" }},
+		{"expired", func(claims *IDTokenClaims) { claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{"no expiry", func(claims *IDTokenClaims) { claims.ExpiresAt = nil }},
+		{"no subject", func(claims *IDTokenClaims) { claims.Subject = "" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.claims = test.claims
+
+			if _, err := login(t, idp, idp.provider(), stateVerifier); err == nil {
+				t.Fatal("ID token was accepted")
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsOtherSigningKeys(t *testing.T) {
+	idp := newMockIdP(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.key = other
+
+	if _, err := login(t, idp, idp.provider(), stateVerifier); err == nil {
+		t.Fatal("ID token signed with an unknown key was accepted")
+	}
+}
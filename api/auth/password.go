@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"rapidvote/api/util"
+)
+
+// breachedPrefixLength is how many hex characters of a SHA-1 hash select a range,
+// the same as the k-anonymity range API of Have I Been Pwned
+const breachedPrefixLength = 5
+
+// PasswordPolicy is configured with the `PASSWORD_*` environment variables
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowEmail rejects passwords that contain the account's email or its local part
+	DisallowEmail bool
+}
+
+var (
+	Policy = loadPasswordPolicy()
+
+	breachedPasswords = loadBreachedPasswords(os.Getenv("BREACHED_PASSWORDS_FILE"))
+)
+
+func loadPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     util.EnvInt("PASSWORD_MIN_LENGTH", 8),
+		// bcrypt ignores anything past 72 bytes
+		MaxLength:     util.EnvInt("PASSWORD_MAX_LENGTH", 72),
+		RequireUpper:  util.EnvBool("PASSWORD_REQUIRE_UPPER", false),
+		RequireLower:  util.EnvBool("PASSWORD_REQUIRE_LOWER", false),
+		RequireDigit:  util.EnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol: util.EnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		DisallowEmail: util.EnvBool("PASSWORD_DISALLOW_EMAIL", true),
+	}
+}
+
+// Validate returns every rule of the policy the password breaks, including
+// whether it shows up in the breached password list
+func (p PasswordPolicy) Validate(password string, email string) []string {
+	var violations []string
+
+	// The minimum is in characters, so that passwords in any script get the same minimum,
+	// while the maximum is in bytes because that's what bcrypt is limited by
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, "must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, "must be at most "+strconv.Itoa(p.MaxLength)+" bytes long")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.DisallowEmail && len(email) > 0 {
+		lowered := strings.ToLower(password)
+		localPart := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+		if strings.Contains(lowered, strings.ToLower(email)) || (len(localPart) >= 3 && strings.Contains(lowered, localPart)) {
+			violations = append(violations, "must not contain your email")
+		}
+	}
+
+	if len(password) > 0 && breachedPasswords.contains(password) {
+		violations = append(violations, "has appeared in a data breach, choose a different one")
+	}
+
+	return violations
+}
+
+// breachedList holds SHA-1 hashes of breached passwords, grouped by hash prefix. Only the
+// range for a password's prefix is ever looked at, just like the HIBP range API.
+//
+// `BREACHED_PASSWORDS_FILE` may either be a single file of `HASH[:COUNT]` lines, which is
+// loaded up front, or a directory of `<PREFIX>.txt` range files of `SUFFIX[:COUNT]` lines
+// (the layout produced by the HIBP downloader), which are read on demand
+type breachedList struct {
+	dir    string
+	ranges map[string]map[string]bool
+}
+
+func loadBreachedPasswords(path string) *breachedList {
+	list := &breachedList{ranges: make(map[string]map[string]bool)}
+	if path == "" {
+		return list
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Couldn't load breached passwords from %s: %s\n", path, err.Error())
+		return list
+	}
+	if info.IsDir() {
+		list.dir = path
+		return list
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Couldn't load breached passwords from %s: %s\n", path, err.Error())
+		return list
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash := strings.ToUpper(strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)[0])
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]bool)
+		}
+		list.ranges[prefix][suffix] = true
+		count++
+	}
+	log.Printf("Loaded %d breached password hashes\n", count)
+	return list
+}
+
+func (l *breachedList) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	// Range files aren't cached, there are far too many of them to keep in memory
+	// and passwords are only checked on registration and password resets
+	if l.dir != "" {
+		return l.loadRange(prefix)[suffix]
+	}
+	return l.ranges[prefix][suffix]
+}
+
+func (l *breachedList) loadRange(prefix string) map[string]bool {
+	suffixes := make(map[string]bool)
+
+	file, err := os.Open(filepath.Join(l.dir, prefix+".txt"))
+	if err != nil {
+		return suffixes
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		suffix := strings.ToUpper(strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)[0])
+		suffixes[suffix] = true
+	}
+	return suffixes
+}
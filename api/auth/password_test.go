@@ -0,0 +1,25 @@
+package auth
+
+import "testing"
+
+func TestPasswordLength(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 72}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"short", false},
+		{"longenough", true},
+		// 4 characters, but 12 bytes
+		{"密码密码", false},
+		{"密码密码密码密码", true},
+		{string(make([]byte, 73)), false},
+	}
+	for _, test := range tests {
+		violations := policy.Validate(test.password, "")
+		if valid := len(violations) == 0; valid != test.valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", test.password, violations, test.valid)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"rapidvote/api/util"
+)
+
+const (
+	apiTokenPrefix              = "rvp_"
+	apiTokenIdLength       uint = 8
+	apiTokenSecretLength   uint = 40
+)
+
+// Scopes a personal API token can be granted
+const (
+	ScopePollsRead  = "polls:read"
+	ScopePollsWrite = "polls:write"
+	ScopeVotesWrite = "votes:write"
+)
+
+var Scopes = []string{ScopePollsRead, ScopePollsWrite, ScopeVotesWrite}
+
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAPIToken creates a new personal API token of the form `rvp_<id>_<secret>`.
+// The short ID is stored in plain text so users can tell their tokens apart;
+// only the hash of the full token is stored
+func GenerateAPIToken() (token string, tokenId string, hash string) {
+	tokenId = util.GenSecureRandomString(apiTokenIdLength)
+	token = apiTokenPrefix + tokenId + "_" + util.GenSecureRandomString(apiTokenSecretLength)
+	return token, tokenId, HashAPIToken(token)
+}
+
+// API tokens are long random strings, so a plain SHA-256 is enough to keep them hashed at rest
+// while still letting the middleware look them up by hash
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+const (
+	invitationTokenPrefix      = "rvi_"
+	invitationTokenLength uint = 32
+)
+
+// GenerateInvitationToken creates a single-use token that lets one invited voter vote on
+// an invitation-only poll. Like API tokens, only the hash is stored
+func GenerateInvitationToken() (token string, hash string) {
+	token = invitationTokenPrefix + util.GenSecureRandomString(invitationTokenLength)
+	return token, HashInvitationToken(token)
+}
+
+func HashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
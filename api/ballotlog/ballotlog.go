@@ -0,0 +1,302 @@
+// Package ballotlog builds and checks the public log of a poll's ballots. Every ballot has a
+// hash, the log chains those hashes together in order, and a Merkle tree over them gives a
+// single root that is published when the poll closes. Nothing in here touches the database,
+// so the same code verifies a downloaded log offline
+package ballotlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"rapidvote/api/elgamal"
+)
+
+var (
+	ErrBallotHash = errors.New("ballot hash doesn't match the ballot")
+	ErrChain      = errors.New("hash chain is broken")
+	ErrSequence   = errors.New("log entries are out of sequence")
+	ErrRoot       = errors.New("merkle root doesn't match the log")
+	ErrProof      = errors.New("inclusion proof doesn't lead to the merkle root")
+	ErrEncrypted  = errors.New("encrypted ballots don't add up to the decrypted tally")
+)
+
+// Entry is one ballot in a poll's log
+type Entry struct {
+	// Seq is the ballot's position in the log, starting at 1
+	Seq      int64
+	BallotId string
+	Choice   uint
+	// Encrypted replaces Choice for polls with encrypted ballots
+	Encrypted  *elgamal.Ballot `json:",omitempty"`
+	CastAt     time.Time
+	BallotHash string
+	// ChainHash covers this ballot and every ballot before it
+	ChainHash string
+}
+
+// Log is a poll's whole ballot log, the way it is published
+type Log struct {
+	PollId  string
+	Options []string
+	Entries []Entry
+	// MerkleRoot is empty until the poll closes, and covers the first MerkleSize entries
+	MerkleRoot string
+	MerkleSize int64
+	// Election and Tally are only there for polls with encrypted ballots, Tally once the
+	// trustees have decrypted it
+	Election *elgamal.ElectionKey `json:",omitempty"`
+	Tally    *elgamal.Tally       `json:",omitempty"`
+}
+
+// Receipt is what a voter gets back for their ballot
+type Receipt struct {
+	PollId   string
+	BallotId string
+	Hash     string
+}
+
+// ProofStep is one sibling on the path from a ballot up to the Merkle root
+type ProofStep struct {
+	Hash string
+	// Left is whether the sibling is on the left of the path
+	Left bool
+}
+
+func sum(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// Content is what a ballot says: its choice, or the fingerprint of its encrypted choices
+func Content(choice uint, encrypted *elgamal.Ballot) string {
+	if encrypted != nil {
+		return encrypted.Fingerprint()
+	}
+	return strconv.FormatUint(uint64(choice), 10)
+}
+
+// BallotHash commits to everything a ballot says. Cast times are hashed in whole seconds
+func BallotHash(pollId string, ballotId string, content string, castAt time.Time) string {
+	data := pollId + "\n" + ballotId + "\n" + content + "\n" + strconv.FormatInt(castAt.Unix(), 10)
+	return hex.EncodeToString(sum([]byte(data)))
+}
+
+// Genesis is the chain hash before a poll's first ballot
+func Genesis(pollId string) string {
+	return hex.EncodeToString(sum([]byte("rapidvote ballot log\n" + pollId)))
+}
+
+// ChainHash extends the chain ending in `prev` with a ballot
+func ChainHash(prev string, ballotHash string) (string, error) {
+	prevBytes, err := hex.DecodeString(prev)
+	if err != nil {
+		return "", err
+	}
+	ballotBytes, err := hex.DecodeString(ballotHash)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum(prevBytes, ballotBytes)), nil
+}
+
+// Leaves and inner nodes are hashed with different prefixes, as in RFC 6962, so an inner
+// node can't pass for a ballot
+func leafHash(ballotHash []byte) []byte {
+	return sum([]byte{0}, ballotHash)
+}
+
+func nodeHash(left []byte, right []byte) []byte {
+	return sum([]byte{1}, left, right)
+}
+
+// split is the largest power of two smaller than n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func decodeAll(hashes []string) ([][]byte, error) {
+	leaves := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leafHash(b)
+	}
+	return leaves, nil
+}
+
+func root(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(root(leaves[:k]), root(leaves[k:]))
+}
+
+// MerkleRoot is the root of the tree over the ballot hashes, in log order. An empty log has
+// the hash of nothing as its root
+func MerkleRoot(ballotHashes []string) (string, error) {
+	if len(ballotHashes) == 0 {
+		return hex.EncodeToString(sum()), nil
+	}
+	leaves, err := decodeAll(ballotHashes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(root(leaves)), nil
+}
+
+func path(leaves [][]byte, index int) []ProofStep {
+	if len(leaves) == 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(path(leaves[:k], index), ProofStep{Hash: hex.EncodeToString(root(leaves[k:])), Left: false})
+	}
+	return append(path(leaves[k:], index-k), ProofStep{Hash: hex.EncodeToString(root(leaves[:k])), Left: true})
+}
+
+// InclusionProof is the path from the ballot at `index` (counting from 0) up to the root
+func InclusionProof(ballotHashes []string, index int) ([]ProofStep, error) {
+	if index < 0 || index >= len(ballotHashes) {
+		return nil, fmt.Errorf("no ballot at index %d", index)
+	}
+	leaves, err := decodeAll(ballotHashes)
+	if err != nil {
+		return nil, err
+	}
+	return path(leaves, index), nil
+}
+
+// VerifyInclusion checks that `proof` leads from the ballot hash to `merkleRoot`
+func VerifyInclusion(ballotHash string, proof []ProofStep, merkleRoot string) error {
+	b, err := hex.DecodeString(ballotHash)
+	if err != nil {
+		return err
+	}
+	expected, err := hex.DecodeString(merkleRoot)
+	if err != nil {
+		return err
+	}
+
+	node := leafHash(b)
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return err
+		}
+		if step.Left {
+			node = nodeHash(sibling, node)
+		} else {
+			node = nodeHash(node, sibling)
+		}
+	}
+	if !bytes.Equal(node, expected) {
+		return ErrProof
+	}
+	return nil
+}
+
+// Verify recomputes every hash in the log and its Merkle root, and tallies its ballots by
+// option index. Encrypted ballots are checked for validity, and tallied from the trustees'
+// decryption once there is one
+func Verify(log Log) (map[uint]int64, error) {
+	tally := make(map[uint]int64)
+	prev := Genesis(log.PollId)
+	var ballotHashes []string
+	for i, entry := range log.Entries {
+		if entry.Seq != int64(i+1) {
+			return nil, fmt.Errorf("%w: expected %d, got %d", ErrSequence, i+1, entry.Seq)
+		}
+		if BallotHash(log.PollId, entry.BallotId, Content(entry.Choice, entry.Encrypted), entry.CastAt) != entry.BallotHash {
+			return nil, fmt.Errorf("%w: ballot %s", ErrBallotHash, entry.BallotId)
+		}
+		chainHash, err := ChainHash(prev, entry.BallotHash)
+		if err != nil {
+			return nil, err
+		}
+		if chainHash != entry.ChainHash {
+			return nil, fmt.Errorf("%w: at ballot %s", ErrChain, entry.BallotId)
+		}
+		prev = chainHash
+		ballotHashes = append(ballotHashes, entry.BallotHash)
+		if entry.Encrypted == nil {
+			tally[entry.Choice]++
+		}
+	}
+
+	if len(log.MerkleRoot) > 0 {
+		if log.MerkleSize > int64(len(ballotHashes)) {
+			return nil, fmt.Errorf("%w: it covers %d ballots, the log has %d", ErrRoot, log.MerkleSize, len(ballotHashes))
+		}
+		merkleRoot, err := MerkleRoot(ballotHashes[:log.MerkleSize])
+		if err != nil {
+			return nil, err
+		}
+		if merkleRoot != log.MerkleRoot {
+			return nil, ErrRoot
+		}
+	}
+
+	if log.Election != nil {
+		if err := verifyEncrypted(log, tally); err != nil {
+			return nil, err
+		}
+	}
+	return tally, nil
+}
+
+// verifyEncrypted checks every encrypted ballot's proofs, and that the decrypted tally is the
+// decryption of the sum of the first Tally.Ballots of them
+func verifyEncrypted(log Log, tally map[uint]int64) error {
+	var ballots []elgamal.Ballot
+	for _, entry := range log.Entries {
+		if entry.Encrypted == nil {
+			continue
+		}
+		if err := elgamal.VerifyBallot(log.Election.PublicKey, log.PollId, len(log.Options), *entry.Encrypted); err != nil {
+			return fmt.Errorf("ballot %s: %w", entry.BallotId, err)
+		}
+		ballots = append(ballots, *entry.Encrypted)
+	}
+	if log.Tally == nil {
+		return nil
+	}
+
+	if log.Tally.Ballots > int64(len(ballots)) {
+		return ErrEncrypted
+	}
+	aggregate, err := elgamal.Aggregate(ballots[:log.Tally.Ballots], len(log.Options))
+	if err != nil {
+		return err
+	}
+	if len(aggregate) != len(log.Tally.Aggregate) {
+		return ErrEncrypted
+	}
+	for i := range aggregate {
+		if aggregate[i] != log.Tally.Aggregate[i] {
+			return ErrEncrypted
+		}
+	}
+	if err = elgamal.VerifyTally(log.PollId, *log.Election, *log.Tally); err != nil {
+		return err
+	}
+	for i, count := range log.Tally.Counts {
+		tally[uint(i)] += count
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package ballotlog
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// The leaves and roots of the Merkle tree tests of RFC 6962 (Certificate Transparency), whose
+// hashing the ballot log follows. Ballot hashes are hex, so the leaves are too
+var (
+	rfc6962Leaves = []string{
+		"",
+		"00",
+		"10",
+		"2021",
+		"3031",
+		"40414243",
+		"5051525354555657",
+		"606162636465666768696a6b6c6d6e6f",
+	}
+	rfc6962Roots = []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+)
+
+func TestMerkleRoot(t *testing.T) {
+	empty, err := MerkleRoot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; empty != want {
+		t.Errorf("empty root = %s, want %s", empty, want)
+	}
+
+	for size := 1; size <= len(rfc6962Leaves); size++ {
+		root, err := MerkleRoot(rfc6962Leaves[:size])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root != rfc6962Roots[size-1] {
+			t.Errorf("root of %d leaves = %s, want %s", size, root, rfc6962Roots[size-1])
+		}
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	// The audit path of the first leaf in the tree of all 8, from RFC 6962's tests
+	proof, err := InclusionProof(rfc6962Leaves, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProofStep{
+		{Hash: "96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7", Left: false},
+		{Hash: "5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e", Left: false},
+		{Hash: "6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4", Left: false},
+	}
+	if len(proof) != len(want) {
+		t.Fatalf("proof = %v, want %v", proof, want)
+	}
+	for i := range want {
+		if proof[i] != want[i] {
+			t.Errorf("step %d = %v, want %v", i, proof[i], want[i])
+		}
+	}
+
+	// Every ballot of every size of tree is proven to be under its root, and under no other
+	for size := 1; size <= len(rfc6962Leaves); size++ {
+		for index := 0; index < size; index++ {
+			proof, err := InclusionProof(rfc6962Leaves[:size], index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = VerifyInclusion(rfc6962Leaves[index], proof, rfc6962Roots[size-1]); err != nil {
+				t.Errorf("leaf %d of %d: %s", index, size, err)
+			}
+			if size > 1 {
+				if err = VerifyInclusion(rfc6962Leaves[index], proof, rfc6962Roots[size-2]); err != ErrProof {
+					t.Errorf("leaf %d of %d verifies under the root of %d leaves", index, size, size-1)
+				}
+			}
+			other := rfc6962Leaves[(index+1)%size]
+			if size > 1 && VerifyInclusion(other, proof, rfc6962Roots[size-1]) != ErrProof {
+				t.Errorf("proof of leaf %d of %d verifies for another leaf", index, size)
+			}
+		}
+	}
+
+	if _, err = InclusionProof(rfc6962Leaves, len(rfc6962Leaves)); err == nil {
+		t.Error("proved a ballot past the end of the log")
+	}
+}
+
+// logOf builds a valid log of plain ballots for `choices`
+func logOf(t *testing.T, pollId string, choices []uint) Log {
+	log := Log{PollId: pollId, Options: []string{"yes", "no"}}
+	prev := Genesis(pollId)
+	var ballotHashes []string
+	castAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, choice := range choices {
+		entry := Entry{
+			Seq:      int64(i + 1),
+			BallotId: string(rune('a' + i)),
+			Choice:   choice,
+			CastAt:   castAt,
+		}
+		entry.BallotHash = BallotHash(pollId, entry.BallotId, Content(choice, nil), castAt)
+		var err error
+		if entry.ChainHash, err = ChainHash(prev, entry.BallotHash); err != nil {
+			t.Fatal(err)
+		}
+		prev = entry.ChainHash
+		ballotHashes = append(ballotHashes, entry.BallotHash)
+		log.Entries = append(log.Entries, entry)
+	}
+	root, err := MerkleRoot(ballotHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.MerkleRoot, log.MerkleSize = root, int64(len(ballotHashes))
+	return log
+}
+
+func TestVerify(t *testing.T) {
+	log := logOf(t, "abcd1234", []uint{0, 1, 1})
+	tally, err := Verify(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally[0] != 1 || tally[1] != 2 {
+		t.Errorf("tally = %v, want 1 yes and 2 no", tally)
+	}
+
+	changed := logOf(t, "abcd1234", []uint{0, 1, 1})
+	changed.Entries[1].Choice = 0
+	if _, err = Verify(changed); !errors.Is(err, ErrBallotHash) {
+		t.Errorf("changed ballot = %v, want %v", err, ErrBallotHash)
+	}
+
+	// Rehashing a changed ballot still breaks the chain after it
+	rehashed := logOf(t, "abcd1234", []uint{0, 1, 1})
+	entry := &rehashed.Entries[1]
+	entry.Choice = 0
+	entry.BallotHash = BallotHash(rehashed.PollId, entry.BallotId, Content(0, nil), entry.CastAt)
+	if _, err = Verify(rehashed); !errors.Is(err, ErrChain) {
+		t.Errorf("rehashed ballot = %v, want %v", err, ErrChain)
+	}
+
+	dropped := logOf(t, "abcd1234", []uint{0, 1, 1})
+	dropped.Entries = dropped.Entries[:2]
+	if _, err = Verify(dropped); !errors.Is(err, ErrRoot) {
+		t.Error("log with a ballot dropped after its root was published verifies")
+	}
+}
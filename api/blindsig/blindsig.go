@@ -0,0 +1,146 @@
+// Package blindsig implements RSA blind signatures for anonymous voting credentials. A voter
+// picks a random nonce, blinds the hash of it and has the server sign that while logged in.
+// Unblinding gives a valid signature on the nonce that the server has never seen, so when the
+// voter later casts a ballot with it, the server can tell the credential is genuine but not
+// whom it was issued to. Hashes are full-domain (RSA-FDH), and numbers are hex strings, as in
+// package elgamal
+package blindsig
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+)
+
+// KeyBits is the size of the keys polls get
+const KeyBits = 2048
+
+var (
+	ErrKey       = errors.New("not a valid credential key")
+	ErrBlinded   = errors.New("blinded message is out of range")
+	ErrSignature = errors.New("credential signature doesn't verify")
+)
+
+// PublicKey is what voters blind their credentials with and the server checks them against
+type PublicKey struct {
+	N string
+	E int
+}
+
+func (key PublicKey) parse() (*big.Int, *big.Int, error) {
+	n, ok := new(big.Int).SetString(key.N, 16)
+	if !ok || n.BitLen() < KeyBits || key.E < 3 {
+		return nil, nil, ErrKey
+	}
+	return n, big.NewInt(int64(key.E)), nil
+}
+
+// Fingerprint identifies the key, so voters can check every credential of a poll was signed
+// with the same one
+func (key PublicKey) Fingerprint() string {
+	digest := sha256.Sum256([]byte(key.N + "|" + big.NewInt(int64(key.E)).Text(16)))
+	return hex.EncodeToString(digest[:])
+}
+
+// GenerateKey creates a signing key and its public half
+func GenerateKey() (*rsa.PrivateKey, PublicKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, KeyBits)
+	if err != nil {
+		return nil, PublicKey{}, err
+	}
+	return private, Public(private), nil
+}
+
+// Public is the public half of a signing key
+func Public(private *rsa.PrivateKey) PublicKey {
+	return PublicKey{N: private.N.Text(16), E: private.E}
+}
+
+// Message is what a credential for a poll signs. The nonce is the voter's own random value,
+// and also what makes each credential spendable only once
+func Message(pollId string, nonce string) []byte {
+	return []byte("rapidvote credential|" + pollId + "|" + nonce)
+}
+
+// hashToInt is the full-domain hash of the message, SHA-256 stretched as in MGF1 to the size
+// of the modulus and reduced by it
+func hashToInt(n *big.Int, message []byte) *big.Int {
+	size := (n.BitLen() + 7) / 8
+	var out []byte
+	counter := make([]byte, 4)
+	for i := uint32(0); len(out) < size; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		digest := sha256.Sum256(append(append([]byte{}, message...), counter...))
+		out = append(out, digest[:]...)
+	}
+	m := new(big.Int).SetBytes(out[:size])
+	return m.Mod(m, n)
+}
+
+// Blind hides the message from the signer. It returns the blinded message to send, and the
+// unblinder to keep for Unblind
+func Blind(key PublicKey, message []byte) (string, string, error) {
+	n, e, err := key.parse()
+	if err != nil {
+		return "", "", err
+	}
+	var r *big.Int
+	for r == nil || new(big.Int).GCD(nil, nil, r, n).Cmp(big.NewInt(1)) != 0 {
+		if r, err = rand.Int(rand.Reader, n); err != nil {
+			return "", "", err
+		}
+	}
+	blinded := new(big.Int).Exp(r, e, n)
+	blinded.Mul(blinded, hashToInt(n, message)).Mod(blinded, n)
+	return blinded.Text(16), r.Text(16), nil
+}
+
+// Sign signs a blinded message without learning what it is
+func Sign(private *rsa.PrivateKey, blinded string) (string, error) {
+	m, ok := new(big.Int).SetString(blinded, 16)
+	if !ok || m.Sign() <= 0 || m.Cmp(private.N) >= 0 {
+		return "", ErrBlinded
+	}
+	return new(big.Int).Exp(m, private.D, private.N).Text(16), nil
+}
+
+// Unblind turns the signature on the blinded message into one on the message itself
+func Unblind(key PublicKey, blindSignature string, unblinder string) (string, error) {
+	n, _, err := key.parse()
+	if err != nil {
+		return "", err
+	}
+	s, ok := new(big.Int).SetString(blindSignature, 16)
+	if !ok {
+		return "", ErrSignature
+	}
+	r, ok := new(big.Int).SetString(unblinder, 16)
+	if !ok {
+		return "", ErrBlinded
+	}
+	rInverse := new(big.Int).ModInverse(r, n)
+	if rInverse == nil {
+		return "", ErrBlinded
+	}
+	return s.Mul(s, rInverse).Mod(s, n).Text(16), nil
+}
+
+// Verify checks a credential's signature on the message
+func Verify(key PublicKey, message []byte, signature string) error {
+	n, e, err := key.parse()
+	if err != nil {
+		return err
+	}
+	s, ok := new(big.Int).SetString(signature, 16)
+	if !ok || s.Sign() <= 0 || s.Cmp(n) >= 0 {
+		return ErrSignature
+	}
+	if new(big.Int).Exp(s, e, n).Cmp(hashToInt(n, message)) != 0 {
+		return ErrSignature
+	}
+	return nil
+}
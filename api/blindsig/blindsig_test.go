@@ -0,0 +1,120 @@
+package blindsig
+
+import (
+	"crypto/rsa"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+var (
+	keyOnce    sync.Once
+	testKey    *rsa.PrivateKey
+	testPublic PublicKey
+)
+
+// key is generated once, since 2048 bit keys take a while
+func key(t *testing.T) (*rsa.PrivateKey, PublicKey) {
+	keyOnce.Do(func() {
+		var err error
+		if testKey, testPublic, err = GenerateKey(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testKey, testPublic
+}
+
+// issue runs the whole exchange: the voter blinds, the server signs, the voter unblinds
+func issue(t *testing.T, private *rsa.PrivateKey, public PublicKey, message []byte) (string, string) {
+	blinded, unblinder, err := Blind(public, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blindSignature, err := Sign(private, blinded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := Unblind(public, blindSignature, unblinder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blinded, signature
+}
+
+func TestBlindSignature(t *testing.T) {
+	private, public := key(t)
+	message := Message("abcd1234", "nonce")
+
+	blinded, signature := issue(t, private, public, message)
+	if err := Verify(public, message, signature); err != nil {
+		t.Fatalf("unblinded signature doesn't verify: %s", err)
+	}
+
+	n, _, err := public.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blinded == hashToInt(n, message).Text(16) {
+		t.Error("the signer saw the message")
+	}
+}
+
+func TestBlindingIsUnlinkable(t *testing.T) {
+	private, public := key(t)
+	message := Message("abcd1234", "nonce")
+
+	firstBlinded, firstSignature := issue(t, private, public, message)
+	secondBlinded, secondSignature := issue(t, private, public, message)
+	if firstBlinded == secondBlinded {
+		t.Error("blinding the same message twice gave the same blinded message")
+	}
+	// Full-domain hash signatures are deterministic, so the server can't mark a credential
+	if firstSignature != secondSignature {
+		t.Error("the same message unblinded to different signatures")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	private, public := key(t)
+	message := Message("abcd1234", "nonce")
+	_, signature := issue(t, private, public, message)
+
+	tampered, _ := new(big.Int).SetString(signature, 16)
+	tampered.Add(tampered, big.NewInt(1))
+	_, otherPublic, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       PublicKey
+		message   []byte
+		signature string
+		want      error
+	}{
+		{"other nonce", public, Message("abcd1234", "other"), signature, ErrSignature},
+		{"other poll", public, Message("efgh5678", "nonce"), signature, ErrSignature},
+		{"tampered signature", public, message, tampered.Text(16), ErrSignature},
+		{"not hex", public, message, "not hex", ErrSignature},
+		{"zero", public, message, "0", ErrSignature},
+		{"modulus", public, message, public.N, ErrSignature},
+		{"other key", otherPublic, message, signature, ErrSignature},
+		{"small key", PublicKey{N: "c5", E: 65537}, message, signature, ErrKey},
+	}
+	for _, test := range tests {
+		if err := Verify(test.key, test.message, test.signature); err != test.want {
+			t.Errorf("%s: Verify = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestSignRejectsOutOfRange(t *testing.T) {
+	private, public := key(t)
+
+	for _, blinded := range []string{"0", "-1", public.N, "not hex"} {
+		if _, err := Sign(private, blinded); err != ErrBlinded {
+			t.Errorf("Sign(%.16s) = %v, want %v", blinded, err, ErrBlinded)
+		}
+	}
+}
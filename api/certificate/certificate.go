@@ -0,0 +1,139 @@
+// Package certificate signs the final results of closed polls, so they can be attached to
+// meeting minutes and checked later for tampering. Certificates are signed with Ed25519 and
+// the key from `RESULTS_SIGNING_KEY`
+package certificate
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Version of the results document format
+const Version = 1
+
+var (
+	ErrMalformed = errors.New("certificate is malformed")
+	ErrWrongKey  = errors.New("certificate was signed with a different key")
+	ErrSignature = errors.New("signature doesn't match the results")
+)
+
+var (
+	signingKey = loadSigningKey(os.Getenv("RESULTS_SIGNING_KEY"))
+)
+
+// loadSigningKey reads a base64 Ed25519 seed or private key. Without one, a key is generated
+// for this run only, so certificates signed with it stop verifying on restart
+func loadSigningKey(encoded string) ed25519.PrivateKey {
+	if len(encoded) == 0 {
+		log.Println("No RESULTS_SIGNING_KEY set, results certificates will only verify until the API restarts")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return key
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		log.Fatalf("RESULTS_SIGNING_KEY isn't valid base64: %s", err.Error())
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw)
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw)
+	default:
+		log.Fatalf("RESULTS_SIGNING_KEY must be a %d byte Ed25519 seed or a %d byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+		return nil
+	}
+}
+
+// Results is the signed document. Counts are indexed like Options
+type Results struct {
+	Version     int
+	PollId      string
+	Name        string
+	Description string
+	Options     []string
+	Counts      []int64
+	Ballots     int64
+	CreatedAt   time.Time
+	ClosedAt    time.Time
+	// MerkleRoot of the poll's ballot log, covering its first MerkleSize ballots
+	MerkleRoot string `json:",omitempty"`
+	MerkleSize int64  `json:",omitempty"`
+	// ElectionKey is the public key of polls with encrypted ballots, whose counts come from
+	// the trustees' decryption
+	ElectionKey string `json:",omitempty"`
+	IssuedAt    time.Time
+}
+
+// Certificate carries the results exactly as they were signed in Payload, and decoded in
+// Results for convenience. Only Payload is trusted when verifying
+type Certificate struct {
+	Results   Results
+	Payload   string
+	Signature string
+	KeyId     string
+}
+
+// PublicKey is the base64 key certificates can be verified with offline
+func PublicKey() string {
+	return base64.StdEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+}
+
+// KeyId is a short fingerprint of the public key, telling keys apart after a rotation
+func KeyId() string {
+	digest := sha256.Sum256(signingKey.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(digest[:8])
+}
+
+// Sign issues a certificate for the results
+func Sign(results Results) (Certificate, error) {
+	results.Version = Version
+	payload, err := json.Marshal(results)
+	if err != nil {
+		return Certificate{}, err
+	}
+	return Certificate{
+		Results:   results,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, payload)),
+		KeyId:     KeyId(),
+	}, nil
+}
+
+// Verify checks the certificate's signature and returns the results it actually signs
+func Verify(certificate Certificate) (Results, error) {
+	payload, err := base64.StdEncoding.DecodeString(certificate.Payload)
+	if err != nil {
+		return Results{}, ErrMalformed
+	}
+	signature, err := base64.StdEncoding.DecodeString(certificate.Signature)
+	if err != nil {
+		return Results{}, ErrMalformed
+	}
+	if certificate.KeyId != KeyId() {
+		return Results{}, ErrWrongKey
+	}
+	if !ed25519.Verify(signingKey.Public().(ed25519.PublicKey), payload, signature) {
+		return Results{}, ErrSignature
+	}
+
+	var results Results
+	if err = json.Unmarshal(payload, &results); err != nil {
+		return Results{}, ErrMalformed
+	}
+	return results, nil
+}
@@ -0,0 +1,190 @@
+// Package challenge issues and checks the challenges anonymous voters solve before they can
+// vote on polls that ask for one: a proof-of-work puzzle, or a CAPTCHA from a provider
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"math/bits"
+	"os"
+	"time"
+
+	"rapidvote/api/util"
+
+	"github.com/golang-jwt/jwt/v4"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// The kinds of challenge a poll can ask for
+const (
+	KindPoW     = "pow"
+	KindCaptcha = "captcha"
+)
+
+const (
+	nonceLength uint = 24
+
+	// Lifetime is how long a challenge can be solved and used for
+	Lifetime time.Duration = 10 * time.Minute
+	// RateWindow is how far back votes count towards a poll's recent vote rate
+	RateWindow time.Duration = 5 * time.Minute
+)
+
+var (
+	ErrInvalid   = errors.New("invalid challenge")
+	ErrWrongKind = errors.New("challenge is of the wrong kind for this poll")
+	ErrUnsolved  = errors.New("challenge wasn't solved")
+
+	jwtPuzzleSecretKey = []byte(os.Getenv("JWT_PUZZLE_SECRET"))
+
+	// Proof-of-work difficulty, in leading zero bits of the solution's hash
+	baseDifficulty = util.EnvInt("POW_BASE_DIFFICULTY", 16)
+	maxDifficulty  = util.EnvInt("POW_MAX_DIFFICULTY", 24)
+	// Every doubling of the votes in RateWindow past this adds a bit of difficulty
+	rateStep = util.EnvInt("POW_RATE_STEP", 20)
+)
+
+// Challenge is what a voter is sent to solve
+type Challenge struct {
+	Kind  string `json:"kind"`
+	Token string `json:"token"`
+	// Difficulty is the number of leading zero bits SHA-256(token + ":" + solution) needs
+	Difficulty int       `json:"difficulty,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	SiteKey    string    `json:"siteKey,omitempty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+type challengeClaims struct {
+	Kind       string `json:"kind"`
+	PollId     string `json:"pollId"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	jwt.StandardClaims
+}
+
+// Solved is a challenge that was checked successfully. Its nonce must be marked as spent
+// until it expires, so the same solution can't be used twice
+type Solved struct {
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+func IsValidKind(kind string) bool {
+	switch kind {
+	case KindPoW:
+		return true
+	case KindCaptcha:
+		return Captcha != nil
+	}
+	return false
+}
+
+// Difficulty scales the proof-of-work difficulty with the number of votes a poll got in the
+// last RateWindow, so stuffing ballots gets more expensive the harder it is tried
+func Difficulty(recentVotes int64) int {
+	difficulty := baseDifficulty
+	if rateStep > 0 && recentVotes > int64(rateStep) {
+		difficulty += int(math.Log2(float64(recentVotes) / float64(rateStep)))
+	}
+	if difficulty > maxDifficulty {
+		return maxDifficulty
+	}
+	return difficulty
+}
+
+// Issue creates a challenge of `kind` for the poll. The difficulty only matters for KindPoW
+func Issue(kind string, pollId string, difficulty int) (Challenge, error) {
+	expiresAt := time.Now().Add(Lifetime)
+	claims := challengeClaims{
+		Kind:   kind,
+		PollId: pollId,
+		StandardClaims: jwt.StandardClaims{
+			Id:        util.GenSecureRandomString(nonceLength),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+	challenge := Challenge{Kind: kind, ExpiresAt: expiresAt}
+
+	switch kind {
+	case KindPoW:
+		claims.Difficulty = difficulty
+		challenge.Difficulty = difficulty
+	case KindCaptcha:
+		if Captcha == nil {
+			return Challenge{}, errors.New("no CAPTCHA provider is configured")
+		}
+		challenge.Provider = Captcha.Name()
+		challenge.SiteKey = Captcha.SiteKey()
+	default:
+		return Challenge{}, errors.New("unknown challenge kind " + kind)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtPuzzleSecretKey)
+	if err != nil {
+		return Challenge{}, err
+	}
+	challenge.Token = token
+	return challenge, nil
+}
+
+// Verify checks that `token` is an unexpired challenge of `kind` for the poll, and that
+// `solution` solves it. For CAPTCHAs the solution is the provider's response token
+func Verify(ctx context.Context, token string, kind string, pollId string, solution string, remoteIP string) (Solved, error) {
+	parsed, err := jwt.ParseWithClaims(token, &challengeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalid
+		}
+		return jwtPuzzleSecretKey, nil
+	})
+	if err != nil {
+		return Solved{}, ErrInvalid
+	}
+	claims := parsed.Claims.(*challengeClaims)
+	if claims.PollId != pollId || len(claims.Id) == 0 {
+		return Solved{}, ErrInvalid
+	}
+	if claims.Kind != kind {
+		return Solved{}, ErrWrongKind
+	}
+
+	switch kind {
+	case KindPoW:
+		if !checkProofOfWork(token, solution, claims.Difficulty) {
+			return Solved{}, ErrUnsolved
+		}
+	case KindCaptcha:
+		if Captcha == nil || len(solution) == 0 {
+			return Solved{}, ErrUnsolved
+		}
+		ok, err := Captcha.Verify(ctx, solution, remoteIP)
+		if err != nil {
+			return Solved{}, err
+		}
+		if !ok {
+			return Solved{}, ErrUnsolved
+		}
+	default:
+		return Solved{}, ErrInvalid
+	}
+
+	return Solved{Nonce: claims.Id, ExpiresAt: time.Unix(claims.ExpiresAt, 0)}, nil
+}
+
+func checkProofOfWork(token string, solution string, difficulty int) bool {
+	if len(solution) == 0 || len(solution) > 64 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
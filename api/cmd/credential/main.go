@@ -0,0 +1,113 @@
+// Command credential does the voter's side of anonymous credentials, the way voting clients
+// do: blinding a fresh credential for a poll, then unblinding the server's signature on it.
+//
+//	go run ./cmd/credential blind -poll abcd1234 -key key.json > pending.json
+//	curl -s -H "Authorization: Bearer $TOKEN" -d '{"pollId": "abcd1234", "blinded": "..."}' \
+//	  https://rapidvote.example/api/polls/credentials/issue > issued.json
+//	go run ./cmd/credential unblind -pending pending.json -issued issued.json
+//
+// key.json is the poll's `credentials` field, as ViewPoll returns it. The unblinded credential
+// goes in the `credential` field of a vote, sent without logging in
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"rapidvote/api/blindsig"
+)
+
+// pending is what the voter keeps between asking for a credential and getting it signed
+type pending struct {
+	PollId    string             `json:"pollId"`
+	Key       blindsig.PublicKey `json:"key"`
+	Nonce     string             `json:"nonce"`
+	Blinded   string             `json:"blinded"`
+	Unblinder string             `json:"unblinder"`
+}
+
+func readJSON(path string, v interface{}) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		log.Fatalf("Couldn't read %s: %s", path, err.Error())
+	}
+}
+
+func writeJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func blind(args []string) {
+	flags := flag.NewFlagSet("blind", flag.ExitOnError)
+	pollId := flags.String("poll", "", "poll ID")
+	keyPath := flags.String("key", "key.json", "the poll's credential key")
+	flags.Parse(args)
+
+	var key blindsig.PublicKey
+	readJSON(*keyPath, &key)
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatal(err)
+	}
+
+	state := pending{PollId: *pollId, Key: key, Nonce: hex.EncodeToString(nonce)}
+	var err error
+	state.Blinded, state.Unblinder, err = blindsig.Blind(key, blindsig.Message(state.PollId, state.Nonce))
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeJSON(state)
+}
+
+func unblind(args []string) {
+	flags := flag.NewFlagSet("unblind", flag.ExitOnError)
+	pendingPath := flags.String("pending", "pending.json", "output of blind")
+	issuedPath := flags.String("issued", "issued.json", "response of the issue endpoint")
+	flags.Parse(args)
+
+	var state pending
+	readJSON(*pendingPath, &state)
+	var issued struct {
+		Metadata struct {
+			BlindSignature string `json:"blindSignature"`
+		} `json:"metadata"`
+	}
+	readJSON(*issuedPath, &issued)
+
+	signature, err := blindsig.Unblind(state.Key, issued.Metadata.BlindSignature, state.Unblinder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// A bad signature here means the server signed something else, or with another key
+	if err = blindsig.Verify(state.Key, blindsig.Message(state.PollId, state.Nonce), signature); err != nil {
+		log.Fatal(err)
+	}
+	writeJSON(map[string]string{
+		"nonce":     state.Nonce,
+		"signature": signature,
+	})
+}
+
+func main() {
+	log.SetFlags(0)
+
+	commands := map[string]func([]string){
+		"blind":   blind,
+		"unblind": unblind,
+	}
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		log.Fatal("Usage: credential blind|unblind [flags]")
+	}
+	commands[os.Args[1]](os.Args[2:])
+}
@@ -0,0 +1,178 @@
+// Command trustee is what the trustees of a poll with encrypted ballots run, offline, to
+// create its election key and later decrypt its tally. Nothing it writes but election.json
+// is ever sent to the server:
+//
+//	go run ./cmd/trustee keygen -threshold 2 -out keys alice bob carol
+//	go run ./cmd/trustee check -election keys/election.json -share keys/share-1.json
+//	curl -s https://rapidvote.example/api/polls/tally/abcd1234 > tally.json
+//	go run ./cmd/trustee decrypt -share keys/share-1.json -tally tally.json > partial.json
+//	curl -s -d @partial.json https://rapidvote.example/api/polls/tally/decrypt
+//
+// The election key goes in the `election` field when creating the poll. Each trustee keeps
+// their own share, and any `threshold` of them can decrypt together.
+//
+// It can also encrypt a ballot, the way voting clients do:
+//
+//	go run ./cmd/trustee encrypt -election keys/election.json -poll abcd1234 -options 3 -choice 1
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"rapidvote/api/elgamal"
+)
+
+func readJSON(path string, v interface{}) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		log.Fatalf("Couldn't read %s: %s", path, err.Error())
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeFile(path string, v interface{}) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	writeJSON(file, v)
+}
+
+func keygen(args []string) {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	threshold := flags.Int("threshold", 0, "number of trustees needed to decrypt")
+	out := flags.String("out", ".", "directory to write the key and shares to")
+	flags.Parse(args)
+
+	names := flags.Args()
+	if *threshold == 0 {
+		*threshold = len(names)/2 + 1
+	}
+	key, shares, err := elgamal.GenerateElectionKey(names, *threshold)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = os.MkdirAll(*out, 0700); err != nil {
+		log.Fatal(err)
+	}
+	writeFile(filepath.Join(*out, "election.json"), key)
+	for _, share := range shares {
+		writeFile(filepath.Join(*out, fmt.Sprintf("share-%d.json", share.Index)), share)
+	}
+	fmt.Printf("Wrote the election key and %d shares to %s, any %d of them can decrypt\n", len(shares), *out, *threshold)
+	fmt.Println("Hand each trustee their share, and delete the rest")
+}
+
+func check(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	electionPath := flags.String("election", "election.json", "election key")
+	sharePath := flags.String("share", "", "share to check")
+	flags.Parse(args)
+
+	var key elgamal.ElectionKey
+	readJSON(*electionPath, &key)
+	if err := key.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if len(*sharePath) > 0 {
+		var share elgamal.Share
+		readJSON(*sharePath, &share)
+		if err := elgamal.VerifyShare(key, share); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Share %d (%s) belongs to the election key\n", share.Index, share.Name)
+	}
+	fmt.Printf("Election key is valid, %d of its %d trustees can decrypt\n", key.Threshold, len(key.Trustees))
+}
+
+func encrypt(args []string) {
+	flags := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	electionPath := flags.String("election", "election.json", "election key")
+	pollId := flags.String("poll", "", "poll ID")
+	options := flags.Int("options", 0, "number of options of the poll")
+	choice := flags.Int("choice", 0, "index of the option to vote for")
+	flags.Parse(args)
+
+	var key elgamal.ElectionKey
+	readJSON(*electionPath, &key)
+	ballot, err := elgamal.EncryptBallot(key.PublicKey, *pollId, *options, *choice)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeJSON(os.Stdout, ballot)
+}
+
+// tally is the part of `GET /api/polls/tally/<pollId>` a trustee needs
+type tally struct {
+	PollId    string               `json:"pollId"`
+	Aggregate []elgamal.Ciphertext `json:"aggregate"`
+}
+
+func readTally(path string) tally {
+	var response struct {
+		Metadata *tally `json:"metadata"`
+	}
+	readJSON(path, &response)
+	if response.Metadata != nil && len(response.Metadata.PollId) > 0 {
+		return *response.Metadata
+	}
+	var raw tally
+	readJSON(path, &raw)
+	return raw
+}
+
+func decrypt(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	sharePath := flags.String("share", "", "your share")
+	tallyPath := flags.String("tally", "tally.json", "the poll's tally")
+	flags.Parse(args)
+
+	var share elgamal.Share
+	readJSON(*sharePath, &share)
+	encrypted := readTally(*tallyPath)
+	if len(encrypted.PollId) == 0 {
+		log.Fatal("Tally has no poll ID")
+	}
+
+	partial, err := elgamal.PartiallyDecrypt(encrypted.PollId, share, encrypted.Aggregate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeJSON(os.Stdout, map[string]interface{}{
+		"pollId":  encrypted.PollId,
+		"partial": partial,
+	})
+}
+
+func main() {
+	log.SetFlags(0)
+
+	commands := map[string]func([]string){
+		"keygen":  keygen,
+		"check":   check,
+		"encrypt": encrypt,
+		"decrypt": decrypt,
+	}
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		log.Fatal("Usage: trustee keygen|check|encrypt|decrypt [flags]")
+	}
+	commands[os.Args[1]](os.Args[2:])
+}
@@ -0,0 +1,97 @@
+// Command verifyballots checks a poll's ballot log offline and recomputes its tally. It reads
+// the response of `GET /api/polls/log/<pollId>`, or just the log in it, from a file or stdin:
+//
+//	curl -s https://rapidvote.example/api/polls/log/abcd1234 > log.json
+//	go run ./cmd/verifyballots log.json
+//
+// Logs of polls with encrypted ballots also get every ballot's proofs checked, and the
+// trustees' decryption of their sum once there is one.
+//
+// It exits with status 1 if any hash or proof in the log doesn't check out
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"rapidvote/api/ballotlog"
+)
+
+func readLog(r io.Reader) (ballotlog.Log, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ballotlog.Log{}, err
+	}
+
+	var response struct {
+		Metadata struct {
+			Log *ballotlog.Log `json:"log"`
+		} `json:"metadata"`
+	}
+	if err = json.Unmarshal(data, &response); err == nil && response.Metadata.Log != nil {
+		return *response.Metadata.Log, nil
+	}
+
+	var ballots ballotlog.Log
+	err = json.Unmarshal(data, &ballots)
+	return ballots, err
+}
+
+func main() {
+	log.SetFlags(0)
+
+	input := io.Reader(os.Stdin)
+	if len(os.Args) > 1 && os.Args[1] != "-" {
+		file, err := os.Open(os.Args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	ballots, err := readLog(input)
+	if err != nil {
+		log.Fatalf("Couldn't read ballot log: %s", err.Error())
+	}
+	if len(ballots.PollId) == 0 {
+		log.Fatal("Ballot log has no poll ID")
+	}
+
+	tally, err := ballotlog.Verify(ballots)
+	if err != nil {
+		fmt.Printf("Ballot log of poll [%s] is INVALID: %s\n", ballots.PollId, err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Ballot log of poll [%s] is valid, with %d ballots\n", ballots.PollId, len(ballots.Entries))
+	if len(ballots.Entries) > 0 {
+		fmt.Printf("Chain head: %s\n", ballots.Entries[len(ballots.Entries)-1].ChainHash)
+	}
+	if len(ballots.MerkleRoot) > 0 {
+		fmt.Printf("Merkle root: %s (covers %d ballots)\n", ballots.MerkleRoot, ballots.MerkleSize)
+	} else {
+		fmt.Println("No Merkle root yet, the poll is still open")
+	}
+
+	if ballots.Election != nil {
+		if ballots.Tally == nil {
+			fmt.Println("Ballots are encrypted, and every one of them is valid. The trustees haven't decrypted the tally yet")
+			return
+		}
+		fmt.Printf("Ballots are encrypted, the first %d of them add up to the tally %d trustees decrypted\n",
+			ballots.Tally.Ballots, len(ballots.Tally.Partials))
+	}
+
+	fmt.Println()
+	for index, option := range ballots.Options {
+		fmt.Printf("%6d  %s\n", tally[uint(index)], option)
+		delete(tally, uint(index))
+	}
+	for choice, count := range tally {
+		fmt.Printf("%6d  (unknown option %d)\n", count, choice)
+	}
+}
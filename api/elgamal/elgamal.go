@@ -0,0 +1,240 @@
+package elgamal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrBallotShape = errors.New("ballot doesn't have one encrypted choice and proof per option")
+	ErrNoResult    = errors.New("tally is larger than the number of ballots")
+)
+
+// Ciphertext is g^r, g^m * h^r for a message m, randomness r and public key h. Multiplying
+// two ciphertexts adds their messages
+type Ciphertext struct {
+	A string
+	B string
+}
+
+func (ct Ciphertext) parse() (*big.Int, *big.Int, error) {
+	a, err := parseElement(ct.A)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := parseElement(ct.B)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// ZeroOrOne proves a ciphertext holds 0 or 1 without telling which, as a disjunction of
+// two EqualLogs proofs where one is simulated
+type ZeroOrOne struct {
+	C0 string
+	C1 string
+	Z0 string
+	Z1 string
+}
+
+// Ballot holds one ciphertext per option, each encrypting 1 if it was chosen and 0 if not
+type Ballot struct {
+	Choices []Ciphertext
+	Proofs  []ZeroOrOne
+	// Sum proves the choices add up to exactly one vote
+	Sum EqualLogs
+}
+
+// BallotContext binds a ballot's proofs to its poll and election key, so a ballot can't be
+// replayed on another poll
+func BallotContext(pollId string, publicKey string) string {
+	return "rapidvote ballot|" + pollId + "|" + publicKey
+}
+
+func encrypt(h *big.Int, m int64) (*big.Int, *big.Int, *big.Int, error) {
+	r, err := randomScalar()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return exp(G, r), mul(exp(G, big.NewInt(m)), exp(h, r)), r, nil
+}
+
+// zeroOrOneCommitments are what the verifier recomputes for branch j of the disjunction
+func zeroOrOneCommitments(h, a, b *big.Int, j int64, c, z *big.Int) (*big.Int, *big.Int) {
+	shifted := div(b, G, big.NewInt(j))
+	return div(exp(G, z), a, c), div(exp(h, z), shifted, c)
+}
+
+func proveZeroOrOne(context string, h, a, b, r *big.Int, m int64) (ZeroOrOne, error) {
+	fake := 1 - m
+	cFake, err := randomScalar()
+	if err != nil {
+		return ZeroOrOne{}, err
+	}
+	zFake, err := randomScalar()
+	if err != nil {
+		return ZeroOrOne{}, err
+	}
+	w, err := randomScalar()
+	if err != nil {
+		return ZeroOrOne{}, err
+	}
+
+	t := make([][2]*big.Int, 2)
+	t[m] = [2]*big.Int{exp(G, w), exp(h, w)}
+	t[fake][0], t[fake][1] = zeroOrOneCommitments(h, a, b, fake, cFake, zFake)
+
+	c := challenge(context, a, b, t[0][0], t[0][1], t[1][0], t[1][1])
+	cReal := subQ(c, cFake)
+	zReal := addQ(w, mulQ(cReal, r))
+
+	cs := [2]*big.Int{}
+	zs := [2]*big.Int{}
+	cs[m], zs[m] = cReal, zReal
+	cs[fake], zs[fake] = cFake, zFake
+	return ZeroOrOne{C0: toHex(cs[0]), C1: toHex(cs[1]), Z0: toHex(zs[0]), Z1: toHex(zs[1])}, nil
+}
+
+func verifyZeroOrOne(context string, h, a, b *big.Int, proof ZeroOrOne) error {
+	var cs, zs [2]*big.Int
+	var err error
+	for i, s := range []string{proof.C0, proof.C1} {
+		if cs[i], err = parseScalar(s); err != nil {
+			return ErrProof
+		}
+	}
+	for i, s := range []string{proof.Z0, proof.Z1} {
+		if zs[i], err = parseScalar(s); err != nil {
+			return ErrProof
+		}
+	}
+
+	t00, t01 := zeroOrOneCommitments(h, a, b, 0, cs[0], zs[0])
+	t10, t11 := zeroOrOneCommitments(h, a, b, 1, cs[1], zs[1])
+	if challenge(context, a, b, t00, t01, t10, t11).Cmp(addQ(cs[0], cs[1])) != 0 {
+		return ErrProof
+	}
+	return nil
+}
+
+// EncryptBallot encrypts a vote for `choice` out of `options`, with its validity proofs.
+// This is what voting clients do, the server never sees the choice
+func EncryptBallot(publicKey string, pollId string, options int, choice int) (Ballot, error) {
+	h, err := parseElement(publicKey)
+	if err != nil {
+		return Ballot{}, err
+	}
+	if choice < 0 || choice >= options {
+		return Ballot{}, fmt.Errorf("choice %d is out of range", choice)
+	}
+
+	context := BallotContext(pollId, publicKey)
+	ballot := Ballot{}
+	sumA, sumB, sumR := big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	for i := 0; i < options; i++ {
+		m := int64(0)
+		if i == choice {
+			m = 1
+		}
+		a, b, r, err := encrypt(h, m)
+		if err != nil {
+			return Ballot{}, err
+		}
+		proof, err := proveZeroOrOne(context, h, a, b, r, m)
+		if err != nil {
+			return Ballot{}, err
+		}
+		ballot.Choices = append(ballot.Choices, Ciphertext{A: toHex(a), B: toHex(b)})
+		ballot.Proofs = append(ballot.Proofs, proof)
+		sumA, sumB, sumR = mul(sumA, a), mul(sumB, b), addQ(sumR, r)
+	}
+
+	ballot.Sum, err = proveEqualLogs(context+"|sum", G, sumA, h, div(sumB, G, one), sumR)
+	if err != nil {
+		return Ballot{}, err
+	}
+	return ballot, nil
+}
+
+// VerifyBallot checks that the ballot encrypts exactly one vote for one of `options` under
+// the election key
+func VerifyBallot(publicKey string, pollId string, options int, ballot Ballot) error {
+	h, err := parseElement(publicKey)
+	if err != nil {
+		return err
+	}
+	if len(ballot.Choices) != options || len(ballot.Proofs) != options {
+		return ErrBallotShape
+	}
+
+	context := BallotContext(pollId, publicKey)
+	sumA, sumB := big.NewInt(1), big.NewInt(1)
+	for i, ct := range ballot.Choices {
+		a, b, err := ct.parse()
+		if err != nil {
+			return err
+		}
+		if err = verifyZeroOrOne(context, h, a, b, ballot.Proofs[i]); err != nil {
+			return fmt.Errorf("option %d: %w", i, err)
+		}
+		sumA, sumB = mul(sumA, a), mul(sumB, b)
+	}
+	if err = verifyEqualLogs(context+"|sum", G, sumA, h, div(sumB, G, one), ballot.Sum); err != nil {
+		return fmt.Errorf("sum: %w", err)
+	}
+	return nil
+}
+
+// Fingerprint is a hash of the ballot's ciphertexts, standing in for its content in the
+// ballot log
+func (ballot Ballot) Fingerprint() string {
+	digest := sha256.New()
+	for _, ct := range ballot.Choices {
+		digest.Write([]byte(ct.A + "|" + ct.B + "\n"))
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// Aggregate multiplies the ballots together option by option, giving ciphertexts of the
+// number of votes for each option
+func Aggregate(ballots []Ballot, options int) ([]Ciphertext, error) {
+	sums := make([][2]*big.Int, options)
+	for i := range sums {
+		sums[i] = [2]*big.Int{big.NewInt(1), big.NewInt(1)}
+	}
+	for _, ballot := range ballots {
+		if len(ballot.Choices) != options {
+			return nil, ErrBallotShape
+		}
+		for i, ct := range ballot.Choices {
+			a, b, err := ct.parse()
+			if err != nil {
+				return nil, err
+			}
+			sums[i] = [2]*big.Int{mul(sums[i][0], a), mul(sums[i][1], b)}
+		}
+	}
+
+	aggregate := make([]Ciphertext, options)
+	for i, sum := range sums {
+		aggregate[i] = Ciphertext{A: toHex(sum[0]), B: toHex(sum[1])}
+	}
+	return aggregate, nil
+}
+
+// discreteLog finds m in [0, max] with g^m = target. Tallies are small, so counting up is
+// fast enough
+func discreteLog(target *big.Int, max int64) (int64, error) {
+	current := big.NewInt(1)
+	for m := int64(0); m <= max; m++ {
+		if current.Cmp(target) == 0 {
+			return m, nil
+		}
+		current = mul(current, G)
+	}
+	return 0, ErrNoResult
+}
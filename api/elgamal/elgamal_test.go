@@ -0,0 +1,231 @@
+package elgamal
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const testPoll = "abcd1234"
+
+func electionKey(t *testing.T) (ElectionKey, []Share) {
+	key, shares, err := GenerateElectionKey([]string{"alice", "bob", "carol"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = key.Validate(); err != nil {
+		t.Fatalf("fresh election key doesn't validate: %s", err)
+	}
+	for _, share := range shares {
+		if err = VerifyShare(key, share); err != nil {
+			t.Fatalf("share %d doesn't verify: %s", share.Index, err)
+		}
+	}
+	return key, shares
+}
+
+func encryptBallot(t *testing.T, key ElectionKey, options int, choice int) Ballot {
+	ballot, err := EncryptBallot(key.PublicKey, testPoll, options, choice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ballot
+}
+
+func TestBallotProofs(t *testing.T) {
+	key, _ := electionKey(t)
+	ballot := encryptBallot(t, key, 3, 1)
+
+	if err := VerifyBallot(key.PublicKey, testPoll, 3, ballot); err != nil {
+		t.Fatalf("ballot doesn't verify: %s", err)
+	}
+	if err := VerifyBallot(key.PublicKey, "efgh5678", 3, ballot); err == nil {
+		t.Error("ballot verifies on another poll")
+	}
+	if err := VerifyBallot(key.PublicKey, testPoll, 2, ballot); err != ErrBallotShape {
+		t.Errorf("ballot for 3 options on a poll with 2 = %v, want %v", err, ErrBallotShape)
+	}
+
+	swapped := Ballot{Choices: append([]Ciphertext{}, ballot.Choices...), Proofs: ballot.Proofs, Sum: ballot.Sum}
+	swapped.Choices[0], swapped.Choices[1] = swapped.Choices[1], swapped.Choices[0]
+	if err := VerifyBallot(key.PublicKey, testPoll, 3, swapped); err == nil {
+		t.Error("ballot verifies with its ciphertexts swapped")
+	}
+}
+
+// TestBallotReencoded checks a ballot can't be sent again with its numbers written another
+// way, which would give it another fingerprint
+func TestBallotReencoded(t *testing.T) {
+	key, _ := electionKey(t)
+	ballot := encryptBallot(t, key, 2, 0)
+
+	for _, reencode := range []func(string) string{
+		func(s string) string { return "0" + s },
+		strings.ToUpper,
+		func(s string) string { return "+" + s },
+	} {
+		copied := Ballot{Choices: append([]Ciphertext{}, ballot.Choices...), Proofs: ballot.Proofs, Sum: ballot.Sum}
+		copied.Choices[0].A = reencode(copied.Choices[0].A)
+		if copied.Fingerprint() == ballot.Fingerprint() {
+			t.Fatal("re-encoding didn't change the fingerprint")
+		}
+		if err := VerifyBallot(key.PublicKey, testPoll, 2, copied); err != ErrElement {
+			t.Errorf("ballot with %s = %v, want %v", copied.Choices[0].A[:4], err, ErrElement)
+		}
+
+		proofCopied := Ballot{Choices: ballot.Choices, Proofs: append([]ZeroOrOne{}, ballot.Proofs...), Sum: ballot.Sum}
+		proofCopied.Proofs[0].Z0 = reencode(proofCopied.Proofs[0].Z0)
+		if err := VerifyBallot(key.PublicKey, testPoll, 2, proofCopied); err == nil {
+			t.Errorf("ballot with a proof of %s verifies", proofCopied.Proofs[0].Z0[:4])
+		}
+	}
+}
+
+// TestBallotWithoutVote builds a ballot whose choices are all valid encryptions of 0, so only
+// the proof that they add up to one vote can catch it
+func TestBallotWithoutVote(t *testing.T) {
+	key, _ := electionKey(t)
+	h, err := parseElement(key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := BallotContext(testPoll, key.PublicKey)
+	ballot := Ballot{}
+	sumA, sumB, sumR := big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	for i := 0; i < 2; i++ {
+		a, b, r, err := encrypt(h, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := proveZeroOrOne(context, h, a, b, r, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ballot.Choices = append(ballot.Choices, Ciphertext{A: toHex(a), B: toHex(b)})
+		ballot.Proofs = append(ballot.Proofs, proof)
+		sumA, sumB, sumR = mul(sumA, a), mul(sumB, b), addQ(sumR, r)
+	}
+	if ballot.Sum, err = proveEqualLogs(context+"|sum", G, sumA, h, div(sumB, G, one), sumR); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = VerifyBallot(key.PublicKey, testPoll, 2, ballot); !errors.Is(err, ErrProof) {
+		t.Errorf("ballot without a vote = %v, want %v", err, ErrProof)
+	}
+}
+
+func TestThresholdDecryption(t *testing.T) {
+	key, shares := electionKey(t)
+	choices := []int{0, 1, 1, 2, 1}
+	want := []int64{1, 3, 1}
+
+	var ballots []Ballot
+	for _, choice := range choices {
+		ballots = append(ballots, encryptBallot(t, key, 3, choice))
+	}
+	aggregate, err := Aggregate(ballots, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partials := make([]PartialDecryption, len(shares))
+	for i, share := range shares {
+		if partials[i], err = PartiallyDecrypt(testPoll, share, aggregate); err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyPartialDecryption(testPoll, key, aggregate, partials[i]); err != nil {
+			t.Fatalf("partial decryption of trustee %d doesn't verify: %s", share.Index, err)
+		}
+	}
+
+	// Any two of the three trustees decrypt the same tally
+	for _, pair := range [][2]int{{0, 1}, {0, 2}, {1, 2}} {
+		tally, err := Combine(testPoll, key, aggregate, int64(len(ballots)), []PartialDecryption{partials[pair[0]], partials[pair[1]]})
+		if err != nil {
+			t.Fatalf("trustees %v: %s", pair, err)
+		}
+		for option := range want {
+			if tally.Counts[option] != want[option] {
+				t.Errorf("trustees %v: counts = %v, want %v", pair, tally.Counts, want)
+				break
+			}
+		}
+		if err = VerifyTally(testPoll, key, tally); err != nil {
+			t.Errorf("trustees %v: tally doesn't verify: %s", pair, err)
+		}
+	}
+
+	tally, err := Combine(testPoll, key, aggregate, int64(len(ballots)), partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tally.Counts[0]++
+	if err = VerifyTally(testPoll, key, tally); err != ErrTally {
+		t.Errorf("tampered tally = %v, want %v", err, ErrTally)
+	}
+}
+
+func TestThresholdRejectsBadPartials(t *testing.T) {
+	key, shares := electionKey(t)
+	aggregate, err := Aggregate([]Ballot{encryptBallot(t, key, 2, 0), encryptBallot(t, key, 2, 1)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := PartiallyDecrypt(testPoll, shares[0], aggregate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Combine(testPoll, key, aggregate, 2, []PartialDecryption{first}); err != ErrThreshold {
+		t.Errorf("one partial decryption with a threshold of 2 = %v, want %v", err, ErrThreshold)
+	}
+	if _, err = Combine(testPoll, key, aggregate, 2, []PartialDecryption{first, first}); err != ErrThreshold {
+		t.Errorf("the same trustee twice = %v, want %v", err, ErrThreshold)
+	}
+
+	// Bob decrypting with his own share, but claiming to be Carol
+	impostor := shares[1]
+	impostor.Index = shares[2].Index
+	forged, err := PartiallyDecrypt(testPoll, impostor, aggregate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyPartialDecryption(testPoll, key, aggregate, forged); err == nil {
+		t.Error("partial decryption made with another trustee's share verifies")
+	}
+	if _, err = Combine(testPoll, key, aggregate, 2, []PartialDecryption{first, forged}); err != ErrThreshold {
+		t.Errorf("a forged partial decryption = %v, want %v", err, ErrThreshold)
+	}
+
+	// Partial decryptions are bound to their poll
+	other, err := PartiallyDecrypt("efgh5678", shares[1], aggregate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyPartialDecryption(testPoll, key, aggregate, other); err == nil {
+		t.Error("partial decryption for another poll verifies")
+	}
+}
+
+func TestElectionKeyValidate(t *testing.T) {
+	key, shares := electionKey(t)
+
+	tampered := key
+	tampered.Trustees = append([]Trustee{}, key.Trustees...)
+	tampered.Trustees[1].VerificationKey = key.Trustees[0].VerificationKey
+	if err := tampered.Validate(); err != ErrElectionKey {
+		t.Errorf("key with a wrong verification key = %v, want %v", err, ErrElectionKey)
+	}
+
+	wrongShare := shares[0]
+	wrongShare.Secret = shares[1].Secret
+	if err := VerifyShare(key, wrongShare); err != ErrShare {
+		t.Errorf("another trustee's secret = %v, want %v", err, ErrShare)
+	}
+
+	if _, _, err := GenerateElectionKey([]string{"alice", "bob"}, 3); err == nil {
+		t.Error("threshold above the number of trustees was accepted")
+	}
+}
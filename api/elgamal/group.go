@@ -0,0 +1,161 @@
+// Package elgamal implements exponential ElGamal encryption for ballots that are tallied
+// without being decrypted one by one. Election keys are split between trustees with Shamir
+// secret sharing, and every step comes with a zero-knowledge proof: ballots prove they hold
+// exactly one vote, trustees prove their partial decryptions are honest. Numbers are passed
+// around as hex strings, so they survive JSON, JavaScript clients and MongoDB alike. Only the
+// lowercase form without leading zeros is accepted, so every number has a single encoding
+package elgamal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// The 2048-bit MODP group from RFC 3526. P is a safe prime, so the quadratic residues form
+// a subgroup of prime order Q, which G generates
+var (
+	P = mustHex("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+		"3995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF")
+	Q = new(big.Int).Rsh(P, 1)
+	G = big.NewInt(2)
+)
+
+var (
+	ErrElement = errors.New("not an element of the group")
+	ErrScalar  = errors.New("not a valid exponent")
+	ErrProof   = errors.New("proof doesn't verify")
+)
+
+var one = big.NewInt(1)
+
+func mustHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("elgamal: bad constant")
+	}
+	return n
+}
+
+func toHex(n *big.Int) string {
+	return n.Text(16)
+}
+
+// parseHex reads a number in the form toHex writes it. Anything else, like uppercase digits,
+// leading zeros or a sign, would let the same ballot be sent again under another encoding
+func parseHex(s string) (*big.Int, bool) {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || toHex(n) != s {
+		return nil, false
+	}
+	return n, true
+}
+
+// parseElement reads a group element, rejecting anything outside the prime order subgroup
+func parseElement(s string) (*big.Int, error) {
+	n, ok := parseHex(s)
+	if !ok || n.Cmp(one) < 0 || n.Cmp(P) >= 0 {
+		return nil, ErrElement
+	}
+	if new(big.Int).Exp(n, Q, P).Cmp(one) != 0 {
+		return nil, ErrElement
+	}
+	return n, nil
+}
+
+func parseScalar(s string) (*big.Int, error) {
+	n, ok := parseHex(s)
+	if !ok || n.Sign() < 0 || n.Cmp(Q) >= 0 {
+		return nil, ErrScalar
+	}
+	return n, nil
+}
+
+func randomScalar() (*big.Int, error) {
+	return rand.Int(rand.Reader, Q)
+}
+
+func exp(base *big.Int, exponent *big.Int) *big.Int {
+	return new(big.Int).Exp(base, exponent, P)
+}
+
+func mul(a *big.Int, b *big.Int) *big.Int {
+	n := new(big.Int).Mul(a, b)
+	return n.Mod(n, P)
+}
+
+func inv(a *big.Int) *big.Int {
+	return new(big.Int).ModInverse(a, P)
+}
+
+// div is a / b^e
+func div(a *big.Int, b *big.Int, e *big.Int) *big.Int {
+	return mul(a, inv(exp(b, e)))
+}
+
+func addQ(a *big.Int, b *big.Int) *big.Int {
+	n := new(big.Int).Add(a, b)
+	return n.Mod(n, Q)
+}
+
+func subQ(a *big.Int, b *big.Int) *big.Int {
+	n := new(big.Int).Sub(a, b)
+	return n.Mod(n, Q)
+}
+
+func mulQ(a *big.Int, b *big.Int) *big.Int {
+	n := new(big.Int).Mul(a, b)
+	return n.Mod(n, Q)
+}
+
+// challenge is the Fiat-Shamir hash of a proof's context and everything it talks about
+func challenge(context string, values ...*big.Int) *big.Int {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = toHex(v)
+	}
+	digest := sha256.Sum256([]byte(context + "|" + strings.Join(parts, "|")))
+	n := new(big.Int).SetBytes(digest[:])
+	return n.Mod(n, Q)
+}
+
+// EqualLogs proves that log_g1(y1) = log_g2(y2) without revealing the logarithm, as in
+// Chaum-Pedersen
+type EqualLogs struct {
+	C string
+	Z string
+}
+
+func proveEqualLogs(context string, g1, y1, g2, y2, x *big.Int) (EqualLogs, error) {
+	w, err := randomScalar()
+	if err != nil {
+		return EqualLogs{}, err
+	}
+	c := challenge(context, g1, y1, g2, y2, exp(g1, w), exp(g2, w))
+	return EqualLogs{C: toHex(c), Z: toHex(addQ(w, mulQ(c, x)))}, nil
+}
+
+func verifyEqualLogs(context string, g1, y1, g2, y2 *big.Int, proof EqualLogs) error {
+	c, err := parseScalar(proof.C)
+	if err != nil {
+		return ErrProof
+	}
+	z, err := parseScalar(proof.Z)
+	if err != nil {
+		return ErrProof
+	}
+	t1 := div(exp(g1, z), y1, c)
+	t2 := div(exp(g2, z), y2, c)
+	if challenge(context, g1, y1, g2, y2, t1, t2).Cmp(c) != 0 {
+		return ErrProof
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package elgamal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrElectionKey = errors.New("election key is inconsistent")
+	ErrShare       = errors.New("share doesn't belong to this election key")
+	ErrThreshold   = errors.New("not enough valid partial decryptions")
+	ErrTally       = errors.New("tally doesn't match its decryptions")
+)
+
+// Trustee is the public side of one trustee's share
+type Trustee struct {
+	// Index is where the trustee's share was taken on the sharing polynomial, from 1
+	Index int
+	Name  string
+	// VerificationKey is g^share, which the trustee's decryption proofs are checked against
+	VerificationKey string
+}
+
+// ElectionKey is everything public about a key split between trustees. Any Threshold of
+// them can decrypt together, fewer learn nothing
+type ElectionKey struct {
+	PublicKey string
+	Threshold int
+	Trustees  []Trustee
+	// Commitments are g to the power of each coefficient of the sharing polynomial, the
+	// first being the public key. They let everyone check the verification keys
+	Commitments []string
+}
+
+// Share is a trustee's secret. It stays with the trustee, the server never sees it
+type Share struct {
+	Index     int
+	Name      string
+	Secret    string
+	PublicKey string
+}
+
+// PartialDecryption is one trustee's share of decrypting the aggregate, with a proof for
+// each option that it was made with their share
+type PartialDecryption struct {
+	Index  int
+	Shares []string
+	Proofs []EqualLogs
+}
+
+// Tally is a decrypted result, with everything needed to check it
+type Tally struct {
+	Aggregate []Ciphertext
+	Ballots   int64
+	Partials  []PartialDecryption
+	Counts    []int64
+}
+
+// GenerateElectionKey creates a key and splits it between the trustees, `threshold` of whom
+// are needed to decrypt. Whoever runs it holds the whole key for a moment, so it is run by
+// the trustees themselves, offline, and nothing but the shares is kept
+func GenerateElectionKey(names []string, threshold int) (ElectionKey, []Share, error) {
+	if threshold < 1 || threshold > len(names) {
+		return ElectionKey{}, nil, fmt.Errorf("threshold must be between 1 and %d", len(names))
+	}
+
+	coefficients := make([]*big.Int, threshold)
+	key := ElectionKey{Threshold: threshold}
+	for j := range coefficients {
+		a, err := randomScalar()
+		if err != nil {
+			return ElectionKey{}, nil, err
+		}
+		coefficients[j] = a
+		key.Commitments = append(key.Commitments, toHex(exp(G, a)))
+	}
+	key.PublicKey = key.Commitments[0]
+
+	var shares []Share
+	for i, name := range names {
+		index := big.NewInt(int64(i + 1))
+		// Horner's rule for f(index)
+		secret := big.NewInt(0)
+		for j := threshold - 1; j >= 0; j-- {
+			secret = addQ(mulQ(secret, index), coefficients[j])
+		}
+		shares = append(shares, Share{Index: i + 1, Name: name, Secret: toHex(secret), PublicKey: key.PublicKey})
+		key.Trustees = append(key.Trustees, Trustee{Index: i + 1, Name: name, VerificationKey: toHex(exp(G, secret))})
+	}
+	return key, shares, nil
+}
+
+// Validate checks the key is well formed, and that every verification key matches the
+// commitments
+func (key ElectionKey) Validate() error {
+	if key.Threshold < 1 || key.Threshold > len(key.Trustees) || len(key.Commitments) != key.Threshold {
+		return ErrElectionKey
+	}
+	if len(key.Commitments) == 0 || key.Commitments[0] != key.PublicKey {
+		return ErrElectionKey
+	}
+	commitments := make([]*big.Int, len(key.Commitments))
+	for j, c := range key.Commitments {
+		n, err := parseElement(c)
+		if err != nil {
+			return ErrElectionKey
+		}
+		commitments[j] = n
+	}
+
+	seen := make(map[int]bool)
+	for _, trustee := range key.Trustees {
+		if trustee.Index < 1 || seen[trustee.Index] {
+			return ErrElectionKey
+		}
+		seen[trustee.Index] = true
+
+		verificationKey, err := parseElement(trustee.VerificationKey)
+		if err != nil {
+			return ErrElectionKey
+		}
+		expected := big.NewInt(1)
+		index := big.NewInt(int64(trustee.Index))
+		power := big.NewInt(1)
+		for _, c := range commitments {
+			expected = mul(expected, exp(c, power))
+			power = mulQ(power, index)
+		}
+		if expected.Cmp(verificationKey) != 0 {
+			return ErrElectionKey
+		}
+	}
+	return nil
+}
+
+func (key ElectionKey) trustee(index int) (Trustee, bool) {
+	for _, trustee := range key.Trustees {
+		if trustee.Index == index {
+			return trustee, true
+		}
+	}
+	return Trustee{}, false
+}
+
+// VerifyShare lets a trustee check their share before the election starts
+func VerifyShare(key ElectionKey, share Share) error {
+	trustee, ok := key.trustee(share.Index)
+	if !ok || share.PublicKey != key.PublicKey {
+		return ErrShare
+	}
+	secret, err := parseScalar(share.Secret)
+	if err != nil {
+		return ErrShare
+	}
+	if toHex(exp(G, secret)) != trustee.VerificationKey {
+		return ErrShare
+	}
+	return nil
+}
+
+func decryptionContext(pollId string, publicKey string) string {
+	return "rapidvote decryption|" + pollId + "|" + publicKey
+}
+
+// PartiallyDecrypt computes the trustee's share of decrypting the aggregate
+func PartiallyDecrypt(pollId string, share Share, aggregate []Ciphertext) (PartialDecryption, error) {
+	secret, err := parseScalar(share.Secret)
+	if err != nil {
+		return PartialDecryption{}, ErrShare
+	}
+	verificationKey := exp(G, secret)
+
+	context := decryptionContext(pollId, share.PublicKey)
+	partial := PartialDecryption{Index: share.Index}
+	for _, ct := range aggregate {
+		a, _, err := ct.parse()
+		if err != nil {
+			return PartialDecryption{}, err
+		}
+		d := exp(a, secret)
+		proof, err := proveEqualLogs(context, G, verificationKey, a, d, secret)
+		if err != nil {
+			return PartialDecryption{}, err
+		}
+		partial.Shares = append(partial.Shares, toHex(d))
+		partial.Proofs = append(partial.Proofs, proof)
+	}
+	return partial, nil
+}
+
+// VerifyPartialDecryption checks that the partial decryption was made with the share of the
+// trustee it claims to come from
+func VerifyPartialDecryption(pollId string, key ElectionKey, aggregate []Ciphertext, partial PartialDecryption) error {
+	trustee, ok := key.trustee(partial.Index)
+	if !ok {
+		return ErrShare
+	}
+	verificationKey, err := parseElement(trustee.VerificationKey)
+	if err != nil {
+		return err
+	}
+	if len(partial.Shares) != len(aggregate) || len(partial.Proofs) != len(aggregate) {
+		return ErrProof
+	}
+
+	context := decryptionContext(pollId, key.PublicKey)
+	for i, ct := range aggregate {
+		a, _, err := ct.parse()
+		if err != nil {
+			return err
+		}
+		d, err := parseElement(partial.Shares[i])
+		if err != nil {
+			return err
+		}
+		if err = verifyEqualLogs(context, G, verificationKey, a, d, partial.Proofs[i]); err != nil {
+			return fmt.Errorf("option %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// lagrange is the coefficient of share `index` when interpolating at 0 from `indices`
+func lagrange(index int, indices []int) *big.Int {
+	numerator, denominator := big.NewInt(1), big.NewInt(1)
+	i := big.NewInt(int64(index))
+	for _, other := range indices {
+		if other == index {
+			continue
+		}
+		j := big.NewInt(int64(other))
+		numerator = mulQ(numerator, j)
+		denominator = mulQ(denominator, subQ(j, i))
+	}
+	return mulQ(numerator, new(big.Int).ModInverse(denominator, Q))
+}
+
+// Combine decrypts the aggregate from the partial decryptions of at least Threshold
+// trustees, checking each of them. Invalid ones are skipped
+func Combine(pollId string, key ElectionKey, aggregate []Ciphertext, ballots int64, partials []PartialDecryption) (Tally, error) {
+	var valid []PartialDecryption
+	var indices []int
+	for _, partial := range partials {
+		if len(valid) == key.Threshold {
+			break
+		}
+		duplicate := false
+		for _, index := range indices {
+			duplicate = duplicate || index == partial.Index
+		}
+		if duplicate || VerifyPartialDecryption(pollId, key, aggregate, partial) != nil {
+			continue
+		}
+		valid = append(valid, partial)
+		indices = append(indices, partial.Index)
+	}
+	if len(valid) < key.Threshold {
+		return Tally{}, ErrThreshold
+	}
+
+	tally := Tally{Aggregate: aggregate, Ballots: ballots, Partials: valid}
+	for option, ct := range aggregate {
+		_, b, err := ct.parse()
+		if err != nil {
+			return Tally{}, err
+		}
+		// a^secret, put together from the trustees' a^share
+		mask := big.NewInt(1)
+		for _, partial := range valid {
+			d, _ := parseElement(partial.Shares[option])
+			mask = mul(mask, exp(d, lagrange(partial.Index, indices)))
+		}
+		count, err := discreteLog(div(b, mask, one), ballots)
+		if err != nil {
+			return Tally{}, err
+		}
+		tally.Counts = append(tally.Counts, count)
+	}
+	return tally, nil
+}
+
+// VerifyTally recomputes a published tally from its partial decryptions
+func VerifyTally(pollId string, key ElectionKey, tally Tally) error {
+	combined, err := Combine(pollId, key, tally.Aggregate, tally.Ballots, tally.Partials)
+	if err != nil {
+		return err
+	}
+	if len(combined.Counts) != len(tally.Counts) {
+		return ErrTally
+	}
+	for i := range combined.Counts {
+		if combined.Counts[i] != tally.Counts[i] {
+			return ErrTally
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageSize int64 = 20
+	maxPageSize     int64 = 100
+)
+
+// pageOptions turns a 1-based page number and page size into find options
+func pageOptions(page int64, pageSize int64) *options.FindOptions {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if page < 1 {
+		page = 1
+	}
+	return options.Find().SetSkip((page - 1) * pageSize).SetLimit(pageSize)
+}
+
+// containsPattern matches fields containing `query`, ignoring case
+func containsPattern(query string) primitive.Regex {
+	return primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+}
+
+func AdminSearchUsers(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.AdminSearchUsers
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	filter := bson.M{}
+	if len(req.Query) > 0 {
+		filter["email"] = containsPattern(req.Query)
+	}
+
+	total, err := UsersColl.CountDocuments(ctx, filter, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count users", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	cursor, err := UsersColl.Find(ctx, filter, pageOptions(req.Page, req.PageSize).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find users", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse users", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found users", gin.H{
+		"users": users,
+		"total": total,
+	})
+}
+
+func AdminSearchPolls(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.AdminSearchPolls
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	filter := bson.M{}
+	if len(req.Query) > 0 {
+		filter["$or"] = bson.A{
+			bson.M{"pollId": req.Query},
+			bson.M{"name": containsPattern(req.Query)},
+			bson.M{"description": containsPattern(req.Query)},
+		}
+	}
+	if len(req.Creator) > 0 {
+		creator, err := primitive.ObjectIDFromHex(req.Creator)
+		if err != nil {
+			responses.Send(c, http.StatusBadRequest, "Malformed poll creator", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		filter["creator"] = creator
+	}
+	if req.Status != nil {
+		filter["status"] = *req.Status
+	}
+
+	total, err := PollsColl.CountDocuments(ctx, filter, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	cursor, err := PollsColl.Find(ctx, filter, pageOptions(req.Page, req.PageSize).SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	polls := []models.Poll{}
+	if err = cursor.All(ctx, &polls); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found polls", gin.H{
+		"polls": polls,
+		"total": total,
+	})
+}
+
+// AdminClosePoll force-closes a poll, whoever created it
+func AdminClosePoll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	moderatorId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.AdminPoll
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	count, err := PollsColl.CountDocuments(ctx, bson.M{"pollId": req.PollId}, options.Count())
+	if err != nil || count == 0 {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+
+	if _, err = closePoll(ctx, req.PollId); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't close poll", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	log.Printf("Poll [%s] was force-closed by %s\n", req.PollId, moderatorId.Hex())
+
+	responses.Send(c, http.StatusOK, "Poll was closed", gin.H{})
+}
+
+// AdminDeletePoll removes an abusive poll along with every vote cast on it
+func AdminDeletePoll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	moderatorId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.AdminPoll
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	polls, votes, err := deletePollData(ctx, []interface{}{req.PollId})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't delete poll", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if polls == 0 {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	log.Printf("Poll [%s] and its %d votes were deleted by %s\n", req.PollId, votes, moderatorId.Hex())
+
+	responses.Send(c, http.StatusOK, "Poll was deleted", gin.H{
+		"deletedVotes": votes,
+	})
+}
+
+// AdminDisableUser disables or re-enables an account. A disabled user is locked
+// out immediately, including their existing sessions and API tokens
+func AdminDisableUser(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adminId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.AdminDisableUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Malformed user ID", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if userId == adminId {
+		responses.Send(c, http.StatusBadRequest, "Admins can't disable their own account", gin.H{})
+		return
+	}
+
+	result, err := UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"disabled": req.Disabled}})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update user", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount != 1 {
+		responses.Send(c, http.StatusNotFound, "User does not exist", gin.H{})
+		return
+	}
+	log.Printf("User %s was set to disabled=%v by %s\n", userId.Hex(), req.Disabled, adminId.Hex())
+
+	responses.Send(c, http.StatusOK, "Updated user", gin.H{
+		"disabled": req.Disabled,
+	})
+}
+
+// AdminSetRole changes a user's role. It takes effect on their next request
+func AdminSetRole(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adminId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.AdminSetRole
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	if !models.IsValidRole(req.Role) {
+		responses.Send(c, http.StatusBadRequest, "Unknown role", gin.H{
+			"role": req.Role,
+		})
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(req.UserId)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Malformed user ID", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if userId == adminId {
+		responses.Send(c, http.StatusBadRequest, "Admins can't change their own role", gin.H{})
+		return
+	}
+
+	result, err := UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"role": req.Role}})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update user", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount != 1 {
+		responses.Send(c, http.StatusNotFound, "User does not exist", gin.H{})
+		return
+	}
+	log.Printf("User %s was given role %s by %s\n", userId.Hex(), req.Role, adminId.Hex())
+
+	responses.Send(c, http.StatusOK, "Updated user role", gin.H{
+		"role": req.Role,
+	})
+}
@@ -0,0 +1,290 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxUserAgentLength int = 512
+
+var (
+	// Votes cast on a poll within this many minutes are compared with each other
+	anomalyWindow = time.Duration(util.EnvInt("ANOMALY_WINDOW_MINUTES", 10)) * time.Minute
+	// This many votes from one subnet within the window is a burst
+	subnetBurstThreshold = int64(util.EnvInt("ANOMALY_SUBNET_THRESHOLD", 10))
+	// This many votes with the same user agent, making up at least userAgentShare percent
+	// of the votes within the window, is suspicious
+	userAgentThreshold = int64(util.EnvInt("ANOMALY_USER_AGENT_THRESHOLD", 20))
+	userAgentShare     = int64(util.EnvInt("ANOMALY_USER_AGENT_SHARE", 80))
+	// A minute with at least spikeMinimum votes, and spikeFactor times the poll's average
+	// per minute over the previous hour, is a spike
+	spikeMinimum = int64(util.EnvInt("ANOMALY_SPIKE_MINIMUM", 30))
+	spikeFactor  = int64(util.EnvInt("ANOMALY_SPIKE_FACTOR", 5))
+)
+
+// voterSubnet groups addresses by /24 for IPv4 and /48 for IPv6, roughly a single network
+func voterSubnet(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	mask := net.CIDRMask(48, 128)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		mask = net.CIDRMask(24, 32)
+	}
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+func voterUserAgent(c *gin.Context) string {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		return userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// detectAnomalies looks for suspicious patterns in the poll's recent votes that `vote`
+// would be part of, and returns a flag for each. Earlier votes that are part of the same
+// subnet burst or user agent pattern are flagged along with it
+func detectAnomalies(ctx context.Context, vote models.Participation) ([]string, error) {
+	var flags []string
+	now := time.Now()
+	recent := bson.M{"$gte": now.Add(-anomalyWindow)}
+
+	if len(vote.VoterSubnet) > 0 {
+		filter := bson.M{"pollId": vote.PollId, "voterSubnet": vote.VoterSubnet, "castAt": recent}
+		fromSubnet, err := ParticipationsColl.CountDocuments(ctx, filter, options.Count())
+		if err != nil {
+			return nil, err
+		}
+		if fromSubnet+1 >= subnetBurstThreshold {
+			flags = append(flags, models.FlagSubnetBurst)
+			if err = flagParticipations(ctx, filter, models.FlagSubnetBurst); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	total, err := ParticipationsColl.CountDocuments(ctx, bson.M{"pollId": vote.PollId, "castAt": recent}, options.Count())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"pollId": vote.PollId, "userAgent": vote.UserAgent, "castAt": recent}
+	if len(vote.UserAgent) == 0 {
+		// Votes from before user agents were recorded don't have one at all
+		filter["userAgent"] = bson.M{"$in": bson.A{"", nil}}
+	}
+	sameUserAgent, err := ParticipationsColl.CountDocuments(ctx, filter, options.Count())
+	if err != nil {
+		return nil, err
+	}
+	if sameUserAgent+1 >= userAgentThreshold && (sameUserAgent+1)*100 >= userAgentShare*(total+1) {
+		flags = append(flags, models.FlagUserAgent)
+		if err = flagParticipations(ctx, filter, models.FlagUserAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	lastMinute, err := ParticipationsColl.CountDocuments(ctx, bson.M{
+		"pollId": vote.PollId,
+		"castAt": bson.M{"$gte": now.Add(-time.Minute)},
+	}, options.Count())
+	if err != nil {
+		return nil, err
+	}
+	lastHour, err := ParticipationsColl.CountDocuments(ctx, bson.M{
+		"pollId": vote.PollId,
+		"castAt": bson.M{"$gte": now.Add(-time.Hour), "$lt": now.Add(-time.Minute)},
+	}, options.Count())
+	if err != nil {
+		return nil, err
+	}
+	// Averaged over the 59 minutes before this one, with at least one vote a minute so
+	// a new poll's first votes aren't a spike
+	baseline := lastHour / 59
+	if baseline < 1 {
+		baseline = 1
+	}
+	if lastMinute+1 >= spikeMinimum && lastMinute+1 >= spikeFactor*baseline {
+		flags = append(flags, models.FlagRateSpike)
+	}
+
+	return flags, nil
+}
+
+// flagParticipations adds `flag` to the participations matching `filter`. Unlike the vote that
+// tripped the check, these can't be quarantined after the fact: their ballots were cast already
+// and nothing leads back to them, so they keep counting. The flag only records that they were
+// part of the pattern
+func flagParticipations(ctx context.Context, filter bson.M, flag string) error {
+	_, err := ParticipationsColl.UpdateMany(ctx, filter, bson.M{"$addToSet": bson.M{"flags": flag}})
+	return err
+}
+
+// findManagedPoll finds a poll the authenticated user may manage, e.g. review votes of or
+// close: one they created, or any poll for moderators. `action` finishes the 403 message.
+// If it returns false, an error response has already been sent
+func findManagedPoll(c *gin.Context, ctx context.Context, pollId string, action string) (models.Poll, primitive.ObjectID, bool) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return models.Poll{}, primitive.NilObjectID, false
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": pollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return models.Poll{}, primitive.NilObjectID, false
+	}
+
+	claims := c.MustGet("accessClaims").(*auth.Claims)
+	if poll.Creator != userId && !models.HasRole(claims.Role, models.RoleModerator) {
+		responses.Send(c, http.StatusForbidden, "Only the poll's creator or a moderator can "+action, gin.H{})
+		return models.Poll{}, primitive.NilObjectID, false
+	}
+	return poll, userId, true
+}
+
+// QuarantinedVotes lists the votes of a poll that are waiting for review
+func QuarantinedVotes(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.QuarantinedVotes
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	poll, _, ok := findManagedPoll(c, ctx, req.PollId, "review its votes")
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"pollId": poll.PollId, "status": models.VoteQuarantined}
+	total, err := ParticipationsColl.CountDocuments(ctx, filter, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	cursor, err := ParticipationsColl.Find(ctx, filter, pageOptions(req.Page, req.PageSize).SetSort(bson.M{"castAt": 1}))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	votes := []models.Participation{}
+	if err = cursor.All(ctx, &votes); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if ballotSecrecy(poll) == models.BallotSecret {
+		for i := range votes {
+			votes[i] = redactVoter(votes[i])
+		}
+	}
+
+	responses.Send(c, http.StatusOK, "Found quarantined votes", gin.H{
+		"votes": votes,
+		"total": total,
+	})
+}
+
+// ReviewVotes accepts quarantined votes, releasing the ballots they held back, or rejects them for good
+func ReviewVotes(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.ReviewVotes
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	poll, reviewerId, ok := findManagedPoll(c, ctx, req.PollId, "review its votes")
+	if !ok {
+		return
+	}
+
+	voteIds := bson.A{}
+	for _, id := range req.VoteIds {
+		voteId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			responses.Send(c, http.StatusBadRequest, "Malformed vote ID", gin.H{
+				"reason": err.Error(),
+				"voteId": id,
+			})
+			return
+		}
+		voteIds = append(voteIds, voteId)
+	}
+
+	status := models.VoteRejected
+	if req.Accept {
+		status = models.VoteAccepted
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": voteIds}, "pollId": poll.PollId, "status": models.VoteQuarantined}
+	cursor, err := ParticipationsColl.Find(ctx, filter, options.Find())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	var participations []models.Participation
+	if err = cursor.All(ctx, &participations); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	reviewed := 0
+	for _, participation := range participations {
+		ok, err := reviewParticipation(ctx, poll, participation, status, reviewerId)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't review votes", gin.H{
+				"reason":   err.Error(),
+				"reviewed": reviewed,
+			})
+			return
+		}
+		if ok {
+			reviewed++
+		}
+	}
+	log.Printf("%d votes of poll [%s] were %s by %s\n", reviewed, poll.PollId, status, reviewerId.Hex())
+
+	responses.Send(c, http.StatusOK, "Reviewed votes", gin.H{
+		"status":   status,
+		"reviewed": reviewed,
+	})
+}
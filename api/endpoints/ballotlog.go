@@ -0,0 +1,300 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"rapidvote/api/ballotlog"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Where a receipt's ballot stands in the poll's ballot log
+const (
+	ReceiptIncluded = "included"
+	// ReceiptPending ballots are cast, but wait for their time bucket to end before they are
+	// appended to the log
+	ReceiptPending  = "pending"
+	ReceiptNotFound = "not_found"
+	ReceiptMismatch = "mismatch"
+)
+
+var (
+	BallotLogsColl *mongo.Collection = database.Mongo.Database("test").Collection("ballot_logs")
+)
+
+func ballotReceipt(ballot models.Ballot) ballotlog.Receipt {
+	return ballotlog.Receipt{
+		PollId:   ballot.PollId,
+		BallotId: ballot.BallotId,
+		Hash:     ballotlog.BallotHash(ballot.PollId, ballot.BallotId, ballotlog.Content(ballot.Choice, ballot.Encrypted), ballot.CastAt),
+	}
+}
+
+func logEntry(ballot models.Ballot) ballotlog.Entry {
+	return ballotlog.Entry{
+		Seq:        ballot.Seq,
+		BallotId:   ballot.BallotId,
+		Choice:     ballot.Choice,
+		Encrypted:  ballot.Encrypted,
+		CastAt:     ballot.CastAt,
+		BallotHash: ballotlog.BallotHash(ballot.PollId, ballot.BallotId, ballotlog.Content(ballot.Choice, ballot.Encrypted), ballot.CastAt),
+		ChainHash:  ballot.ChainHash,
+	}
+}
+
+// loggedBallots are the ballots already in the poll's log, in log order
+func loggedBallots(ctx context.Context, pollId string) ([]models.Ballot, error) {
+	filter := bson.M{"pollId": pollId, "seq": bson.M{"$exists": true}}
+	cursor, err := BallotsColl.Find(ctx, filter, options.Find().SetSort(bson.M{"seq": 1}))
+	if err != nil {
+		return nil, err
+	}
+	ballots := []models.Ballot{}
+	if err = cursor.All(ctx, &ballots); err != nil {
+		return nil, err
+	}
+	return ballots, nil
+}
+
+// appendBallotLog appends the poll's ballots from time buckets that have ended to its log.
+// Within a bucket they go in the order of their random IDs, so the log doesn't give away
+// the order they were cast in. Sealing appends every ballot left and publishes the Merkle root
+func appendBallotLog(ctx context.Context, pollId string, seal bool) (models.BallotLog, error) {
+	var chain models.BallotLog
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		err := BallotLogsColl.FindOne(sc, bson.M{"_id": pollId}, options.FindOne()).Decode(&chain)
+		if err == mongo.ErrNoDocuments {
+			chain = models.BallotLog{PollId: pollId, Head: ballotlog.Genesis(pollId)}
+		} else if err != nil {
+			return err
+		}
+
+		filter := bson.M{"pollId": pollId, "seq": bson.M{"$exists": false}, "held": countedBallot}
+		if !seal {
+			filter["castAt"] = bson.M{"$lt": time.Now().Truncate(ballotTimeBucket)}
+		}
+		sort := bson.D{{Key: "castAt", Value: 1}, {Key: "_id", Value: 1}}
+		cursor, err := BallotsColl.Find(sc, filter, options.Find().SetSort(sort))
+		if err != nil {
+			return err
+		}
+		var ballots []models.Ballot
+		if err = cursor.All(sc, &ballots); err != nil {
+			return err
+		}
+		if len(ballots) == 0 && (!seal || (chain.SealedAt != nil && chain.MerkleSize == chain.Size)) {
+			return nil
+		}
+
+		previousSize := chain.Size
+		for _, ballot := range ballots {
+			chainHash, err := ballotlog.ChainHash(chain.Head, logEntry(ballot).BallotHash)
+			if err != nil {
+				return err
+			}
+			chain.Size++
+			chain.Head = chainHash
+			_, err = BallotsColl.UpdateOne(sc, bson.M{"_id": ballot.BallotId}, bson.M{"$set": bson.M{
+				"seq":       chain.Size,
+				"chainHash": chainHash,
+			}})
+			if err != nil {
+				return err
+			}
+		}
+
+		if seal {
+			logged, err := loggedBallots(sc, pollId)
+			if err != nil {
+				return err
+			}
+			var ballotHashes []string
+			for _, ballot := range logged {
+				ballotHashes = append(ballotHashes, logEntry(ballot).BallotHash)
+			}
+			if chain.MerkleRoot, err = ballotlog.MerkleRoot(ballotHashes); err != nil {
+				return err
+			}
+			chain.MerkleSize = chain.Size
+			if chain.SealedAt == nil {
+				now := time.Now()
+				chain.SealedAt = &now
+			}
+		}
+
+		// Matching the size we started from makes concurrent appends conflict instead of
+		// both extending the same head
+		_, err = BallotLogsColl.ReplaceOne(sc, bson.M{"_id": pollId, "size": previousSize}, chain,
+			options.Replace().SetUpsert(true))
+		return err
+	})
+	return chain, err
+}
+
+// GetBallotLog publishes a poll's ballot log, which anyone who can see the results may
+// download and check with the verifyballots command
+func GetBallotLog(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": c.Params.ByName("pollId")}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !checkPollAccess(c, poll, "") {
+		return
+	}
+	if !checkResultsVisible(c, ctx, poll, c.Query("invitation")) {
+		return
+	}
+	if !checkExactCounts(c, poll) {
+		return
+	}
+
+	chain, err := appendBallotLog(ctx, poll.PollId, isPollClosed(poll))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update ballot log", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	ballots, err := loggedBallots(ctx, poll.PollId)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find ballots", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	entries := []ballotlog.Entry{}
+	for _, ballot := range ballots {
+		entries = append(entries, logEntry(ballot))
+	}
+	published := ballotlog.Log{
+		PollId:     poll.PollId,
+		Options:    poll.Options,
+		Entries:    entries,
+		MerkleRoot: chain.MerkleRoot,
+		MerkleSize: chain.MerkleSize,
+		Election:   poll.Election,
+	}
+	if poll.Election != nil {
+		var tally models.EncryptedTally
+		err = TalliesColl.FindOne(ctx, bson.M{"_id": poll.PollId}, options.FindOne()).Decode(&tally)
+		if err != nil && err != mongo.ErrNoDocuments {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't find tally", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		published.Tally = tally.Result
+	}
+
+	responses.Send(c, http.StatusOK, "Found ballot log", gin.H{
+		"log":  published,
+		"head": chain.Head,
+	})
+}
+
+// CheckReceipt tells a voter whether the ballot on their receipt made it into the poll's log
+// unchanged. Once the poll is closed, it also proves the ballot is under the Merkle root
+func CheckReceipt(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var req requests.CheckReceipt
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	// A ballot's place in the log and its Merkle proof give away how many ballots there are
+	if !checkPollAccess(c, poll, req.Grant) || !checkExactCounts(c, poll) {
+		return
+	}
+
+	chain, err := appendBallotLog(ctx, poll.PollId, isPollClosed(poll))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update ballot log", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var ballot models.Ballot
+	err = BallotsColl.FindOne(ctx, bson.M{"_id": req.BallotId, "pollId": poll.PollId}, options.FindOne()).Decode(&ballot)
+	if err == mongo.ErrNoDocuments {
+		responses.Send(c, http.StatusOK, "Ballot isn't in the log", gin.H{
+			"status": ReceiptNotFound,
+		})
+		return
+	} else if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find ballot", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// The ballot's content stays private unless the receipt proves the caller cast it
+	entry := logEntry(ballot)
+	if entry.BallotHash != req.Hash {
+		responses.Send(c, http.StatusOK, "Receipt doesn't match the ballot", gin.H{
+			"status": ReceiptMismatch,
+		})
+		return
+	}
+	if ballot.Seq == 0 {
+		responses.Send(c, http.StatusOK, "Ballot will be appended to the log once its time bucket ends", gin.H{
+			"status": ReceiptPending,
+			"entry":  entry,
+		})
+		return
+	}
+
+	var proof []ballotlog.ProofStep
+	if len(chain.MerkleRoot) > 0 && ballot.Seq <= chain.MerkleSize {
+		logged, err := loggedBallots(ctx, poll.PollId)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't find ballots", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		var ballotHashes []string
+		for _, b := range logged[:chain.MerkleSize] {
+			ballotHashes = append(ballotHashes, logEntry(b).BallotHash)
+		}
+		if proof, err = ballotlog.InclusionProof(ballotHashes, int(ballot.Seq-1)); err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't prove inclusion", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+	}
+
+	responses.Send(c, http.StatusOK, "Ballot is in the log", gin.H{
+		"status":     ReceiptIncluded,
+		"entry":      entry,
+		"merkleRoot": chain.MerkleRoot,
+		"proof":      proof,
+	})
+}
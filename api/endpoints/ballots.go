@@ -0,0 +1,413 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"rapidvote/api/challenge"
+	"rapidvote/api/database"
+	"rapidvote/api/elgamal"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ballotIdLength uint = 24
+
+var (
+	// ParticipationsColl records who voted on which poll, BallotsColl what was voted for.
+	// Nothing links a ballot to its participation
+	ParticipationsColl *mongo.Collection = database.Mongo.Database("test").Collection("participations")
+	BallotsColl        *mongo.Collection = database.Mongo.Database("test").Collection("ballots")
+	// legacyVotesColl holds votes from before they were split, until MigrateLegacyVotes runs
+	legacyVotesColl *mongo.Collection = database.Mongo.Database("test").Collection("votes")
+
+	// Ballot times are rounded down to this many minutes, so they can't be matched with the
+	// time of a participation
+	ballotTimeBucket = time.Duration(util.EnvInt("BALLOT_TIME_BUCKET_MINUTES", 60)) * time.Minute
+
+	participationIndexOnce sync.Once
+
+	// heldBallotKey keys the references quarantined participations keep to their held ballot
+	heldBallotKey = loadHeldBallotKey()
+)
+
+func loadHeldBallotKey() []byte {
+	key := os.Getenv("HELD_BALLOT_SECRET")
+	if len(key) == 0 {
+		log.Println("No HELD_BALLOT_SECRET set, anyone reading the database can match quarantined votes with their ballots")
+	}
+	return []byte(key)
+}
+
+// heldBallotRef is what a quarantined participation keeps of its held ballot: a keyed hash of
+// the ballot's ID, so the database alone doesn't tell which ballot is whose
+func heldBallotRef(ballotId string) string {
+	mac := hmac.New(sha256.New, heldBallotKey)
+	mac.Write([]byte(ballotId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// findHeldBallot finds the held ballot a participation refers to, by hashing the IDs of the
+// poll's held ballots until one matches. It returns mongo.ErrNoDocuments when none does
+func findHeldBallot(ctx context.Context, pollId string, ref string) (models.Ballot, error) {
+	var ballot models.Ballot
+	cursor, err := BallotsColl.Find(ctx, bson.M{"pollId": pollId, "held": true}, options.Find())
+	if err != nil {
+		return ballot, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		if err = cursor.Decode(&ballot); err != nil {
+			return ballot, err
+		}
+		if hmac.Equal([]byte(heldBallotRef(ballot.BallotId)), []byte(ref)) {
+			return ballot, nil
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return ballot, err
+	}
+	return ballot, mongo.ErrNoDocuments
+}
+
+// errAlreadyVoted is returned when casting a vote with the dedupe keys of one cast before
+var errAlreadyVoted = errors.New("voter already voted on the poll")
+
+// countedBallot matches the "held" field of ballots that count, leaving out those held back
+// for review
+var countedBallot = bson.M{"$ne": true}
+
+// ballotSecrecy is the poll's setting, with legacy polls falling back to secret
+func ballotSecrecy(poll models.Poll) string {
+	if len(poll.BallotSecrecy) == 0 {
+		return models.BallotSecret
+	}
+	return poll.BallotSecrecy
+}
+
+// newBallot creates the ballot for a vote cast at `castAt`, holding either the choice or,
+// on polls with encrypted ballots, the encrypted choices. Only public ballots say who cast them
+func newBallot(poll models.Poll, choice uint, encrypted *elgamal.Ballot, voterId primitive.ObjectID, castAt time.Time) models.Ballot {
+	ballot := models.Ballot{
+		BallotId:  util.GenSecureRandomString(ballotIdLength),
+		PollId:    poll.PollId,
+		Choice:    choice,
+		Encrypted: encrypted,
+		CastAt:    castAt.Truncate(ballotTimeBucket),
+	}
+	if ballotSecrecy(poll) == models.BallotPublic {
+		ballot.VoterId = voterId
+	}
+	return ballot
+}
+
+// inTransaction runs `fn` in a transaction, retrying it on transient errors
+func inTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	return database.Mongo.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
+
+// ensureParticipationIndexes creates the unique index on dedupe keys. Indexes can't be
+// created inside a transaction, so this runs before the first vote is cast
+func ensureParticipationIndexes(ctx context.Context) {
+	participationIndexOnce.Do(func() {
+		_, err := ParticipationsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.M{"dedupeKeys": 1},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		})
+		if err != nil {
+			log.Printf("Couldn't create participation dedupe index: %s\n", err.Error())
+		}
+	})
+}
+
+// castVote records the participation and casts its ballot together, so there is never one
+// without the other. Quarantined votes get a held ballot, which only the participation
+// refers to, through heldBallotRef, until the vote is reviewed, and no receipt. It returns the ballot of votes that
+// count, or errAlreadyVoted when a vote with the same dedupe keys got in first. The solution of
+// the vote's challenge, if it had one, is spent along with it
+func castVote(ctx context.Context, poll models.Poll, participation models.Participation, choice uint, encrypted *elgamal.Ballot, solved *challenge.Solved) (*models.Ballot, error) {
+	ensureParticipationIndexes(ctx)
+	ballot := newBallot(poll, choice, encrypted, participation.VoterId, participation.CastAt)
+	if participation.Status == models.VoteQuarantined {
+		ballot.Held = true
+		participation.HeldBallot = heldBallotRef(ballot.BallotId)
+	}
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		_, err := ParticipationsColl.InsertOne(sc, participation)
+		if mongo.IsDuplicateKeyError(err) {
+			return errAlreadyVoted
+		} else if err != nil {
+			return err
+		}
+		if err = spendChallenge(sc, poll.PollId, solved); err != nil {
+			return err
+		}
+		if err = recordBallotFingerprint(sc, poll.PollId, ballot.Encrypted); err != nil {
+			return err
+		}
+		_, err = BallotsColl.InsertOne(sc, ballot)
+		return err
+	})
+	if err != nil || ballot.Held {
+		return nil, err
+	}
+	return &ballot, nil
+}
+
+// reviewParticipation accepts or rejects a quarantined vote. Accepting releases the ballot it
+// held back and rejecting deletes it, and either way the participation forgets which ballot
+// it was. It reports whether the participation was still waiting for review
+func reviewParticipation(ctx context.Context, poll models.Poll, participation models.Participation, status string, reviewerId primitive.ObjectID) (bool, error) {
+	reviewed := false
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		filter := bson.M{"_id": participation.Id, "status": models.VoteQuarantined}
+		result, err := ParticipationsColl.UpdateOne(sc, filter, bson.M{
+			"$set": bson.M{
+				"status":     status,
+				"reviewedBy": reviewerId,
+				"reviewedAt": time.Now(),
+			},
+			"$unset": bson.M{"heldBallot": ""},
+		})
+		if err != nil {
+			return err
+		}
+		reviewed = result.ModifiedCount == 1
+		if !reviewed || len(participation.HeldBallot) == 0 {
+			return nil
+		}
+		ballot, err := findHeldBallot(sc, poll.PollId, participation.HeldBallot)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		held := bson.M{"_id": ballot.BallotId, "held": true}
+		if status == models.VoteAccepted {
+			_, err = BallotsColl.UpdateOne(sc, held, bson.M{"$unset": bson.M{"held": ""}})
+		} else {
+			_, err = BallotsColl.DeleteOne(sc, held)
+		}
+		return err
+	})
+	return reviewed, err
+}
+
+// redactVoter removes everything that identifies who cast a vote on a secret poll, so
+// the vote can be shown without linking the voter to their choice
+func redactVoter(participation models.Participation) models.Participation {
+	participation.VoterId = primitive.NilObjectID
+	participation.VoterAddr = ""
+	participation.VoterIPChain = nil
+	participation.VoterDevice = ""
+	participation.VoterSubnet = ""
+	participation.DedupeKeys = nil
+	return participation
+}
+
+// anonymizeVoter is the update that removes, from participations kept after their voter is
+// gone, everything redactVoter leaves out of the ones it shows, along with the user agent
+var anonymizeVoter = bson.M{
+	"$set": bson.M{"voterId": primitive.NilObjectID},
+	"$unset": bson.M{
+		"voterAddr":    "",
+		"voterIPChain": "",
+		"voterDevice":  "",
+		"voterSubnet":  "",
+		"dedupeKeys":   "",
+		"userAgent":    "",
+	},
+}
+
+// legacyVote is a vote from before participations and ballots were split
+type legacyVote struct {
+	models.Participation `bson:",inline"`
+	Choice               uint `bson:"choice"`
+}
+
+// MigrateLegacyVotes splits every vote left in the old votes collection into a participation
+// and a ballot, then deletes it. Each vote is moved in its own transaction, so an interrupted
+// migration picks up where it stopped on the next start
+func MigrateLegacyVotes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cursor, err := legacyVotesColl.Find(ctx, bson.M{}, options.Find())
+	if err != nil {
+		log.Printf("Couldn't find legacy votes: %s\n", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	polls := make(map[string]models.Poll)
+	migrated := 0
+	for cursor.Next(ctx) {
+		var vote legacyVote
+		if err := cursor.Decode(&vote); err != nil {
+			log.Printf("Couldn't parse legacy vote: %s\n", err.Error())
+			continue
+		}
+
+		poll, ok := polls[vote.PollId]
+		if !ok {
+			// Votes of deleted polls still become participations, their secrecy is the default
+			err := PollsColl.FindOne(ctx, bson.M{"pollId": vote.PollId}, options.FindOne()).Decode(&poll)
+			if err != nil && err != mongo.ErrNoDocuments {
+				log.Printf("Couldn't find poll [%s] of legacy vote: %s\n", vote.PollId, err.Error())
+				continue
+			}
+			poll.PollId = vote.PollId
+			polls[vote.PollId] = poll
+		}
+
+		participation := vote.Participation
+		ballot := newBallot(poll, vote.Choice, nil, vote.VoterId, vote.CastAt)
+		if participation.Status == models.VoteQuarantined {
+			ballot.Held = true
+			participation.HeldBallot = heldBallotRef(ballot.BallotId)
+		}
+		err = inTransaction(ctx, func(sc mongo.SessionContext) error {
+			if _, err := ParticipationsColl.InsertOne(sc, participation); err != nil {
+				return err
+			}
+			if participation.Status != models.VoteRejected {
+				if _, err := BallotsColl.InsertOne(sc, ballot); err != nil {
+					return err
+				}
+			}
+			_, err := legacyVotesColl.DeleteOne(sc, bson.M{"_id": vote.Id})
+			return err
+		})
+		if err != nil {
+			log.Printf("Couldn't migrate legacy vote %s: %s\n", vote.Id.Hex(), err.Error())
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d legacy votes to participations and ballots\n", migrated)
+	}
+}
+
+// ListVoters lists who voted for an option of a poll with public ballots. Polls with secret
+// ballots act as if the endpoint didn't exist
+func ListVoters(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.PollVoters
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil || ballotSecrecy(poll) != models.BallotPublic {
+		responses.Send(c, http.StatusNotFound, "Not found", gin.H{})
+		return
+	}
+	if !checkPollAccess(c, poll, req.Grant) {
+		return
+	}
+
+	// Who voted for what is part of the results, and the total counts them exactly
+	if !checkResultsVisible(c, ctx, poll, "") || !checkExactCounts(c, poll) {
+		return
+	}
+
+	if int(req.Choice) >= len(poll.Options) {
+		responses.Send(c, http.StatusBadRequest, "Unknown option", gin.H{
+			"choice": req.Choice,
+		})
+		return
+	}
+
+	filter := bson.M{"pollId": poll.PollId, "choice": req.Choice, "voterId": bson.M{"$exists": true}, "held": countedBallot}
+	total, err := BallotsColl.CountDocuments(ctx, filter, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// Never in insertion order, which would give away the order ballots were cast in
+	sort := bson.D{{Key: "castAt", Value: 1}, {Key: "_id", Value: 1}}
+	cursor, err := BallotsColl.Find(ctx, filter, pageOptions(req.Page, req.PageSize).SetSort(sort))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find ballots", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	var ballots []models.Ballot
+	if err = cursor.All(ctx, &ballots); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse ballots", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	voterIds := bson.A{}
+	for _, ballot := range ballots {
+		voterIds = append(voterIds, ballot.VoterId)
+	}
+	cursor, err = UsersColl.Find(ctx, bson.M{"_id": bson.M{"$in": voterIds}}, options.Find())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find voters", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse voters", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	profiles := make(map[primitive.ObjectID]models.PublicProfile)
+	for _, user := range users {
+		profiles[user.Id] = publicProfile(user)
+	}
+
+	// Voters whose accounts are gone still voted, they just don't have a profile anymore
+	voters := []models.PublicProfile{}
+	for _, ballot := range ballots {
+		profile, ok := profiles[ballot.VoterId]
+		if !ok {
+			profile = models.PublicProfile{UserId: ballot.VoterId}
+		}
+		voters = append(voters, profile)
+	}
+
+	responses.Send(c, http.StatusOK, "Found voters", gin.H{
+		"option": poll.Options[req.Choice],
+		"voters": voters,
+		"total":  total,
+	})
+}
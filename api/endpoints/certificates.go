@@ -0,0 +1,177 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"rapidvote/api/certificate"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	CertificatesColl *mongo.Collection = database.Mongo.Database("test").Collection("certificates")
+)
+
+// issuedCertificate is the one certificate a poll gets when it closes
+type issuedCertificate struct {
+	PollId      string                  `bson:"_id"`
+	Certificate certificate.Certificate `bson:"certificate"`
+}
+
+// closedAt is when the poll stopped taking votes
+func closedAt(poll models.Poll) time.Time {
+	if poll.ClosedAt != nil && (poll.Expiration.IsZero() || poll.ClosedAt.Before(poll.Expiration)) {
+		return *poll.ClosedAt
+	}
+	return poll.Expiration
+}
+
+// issueCertificate certifies the results of a closed poll, or returns the certificate it
+// already got. Votes accepted from quarantine after that aren't covered by it
+func issueCertificate(ctx context.Context, pollId string) (certificate.Certificate, error) {
+	var issued issuedCertificate
+	err := CertificatesColl.FindOne(ctx, bson.M{"_id": pollId}, options.FindOne()).Decode(&issued)
+	if err == nil {
+		return issued.Certificate, nil
+	} else if err != mongo.ErrNoDocuments {
+		return certificate.Certificate{}, err
+	}
+
+	var poll models.Poll
+	if err = PollsColl.FindOne(ctx, bson.M{"pollId": pollId}, options.FindOne()).Decode(&poll); err != nil {
+		return certificate.Certificate{}, err
+	}
+	count, err := countVotes(ctx, poll)
+	if err != nil {
+		return certificate.Certificate{}, err
+	}
+	chain, err := appendBallotLog(ctx, pollId, true)
+	if err != nil {
+		return certificate.Certificate{}, err
+	}
+
+	results := certificate.Results{
+		PollId:      poll.PollId,
+		Name:        poll.Name,
+		Description: poll.Description,
+		Options:     poll.Options,
+		CreatedAt:   poll.Id.Timestamp().UTC(),
+		ClosedAt:    closedAt(poll).UTC(),
+		MerkleRoot:  chain.MerkleRoot,
+		MerkleSize:  chain.MerkleSize,
+		IssuedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	for optionIndex := range poll.Options {
+		results.Counts = append(results.Counts, count[optionIndex])
+		results.Ballots += count[optionIndex]
+	}
+	if poll.Election != nil {
+		results.ElectionKey = poll.Election.PublicKey
+	}
+	signed, err := certificate.Sign(results)
+	if err != nil {
+		return certificate.Certificate{}, err
+	}
+
+	// Two requests may certify the poll at once, and only the first certificate is kept
+	_, err = CertificatesColl.InsertOne(ctx, issuedCertificate{PollId: pollId, Certificate: signed})
+	if mongo.IsDuplicateKeyError(err) {
+		return issueCertificate(ctx, pollId)
+	} else if err != nil {
+		return certificate.Certificate{}, err
+	}
+	return signed, nil
+}
+
+// GetCertificate serves the signed results of a closed poll
+func GetCertificate(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": c.Params.ByName("pollId")}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !checkPollAccess(c, poll, "") {
+		return
+	}
+	if !checkResultsVisible(c, ctx, poll, c.Query("invitation")) {
+		return
+	}
+	if !checkExactCounts(c, poll) {
+		return
+	}
+	if !isPollClosed(poll) {
+		responses.Send(c, http.StatusConflict, "Results are certified once the poll closes", gin.H{})
+		return
+	}
+
+	signed, err := issueCertificate(ctx, poll.PollId)
+	if err == errNotDecrypted {
+		responses.Send(c, http.StatusConflict, "Results are certified once the trustees decrypt them", gin.H{})
+		return
+	} else if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't certify results", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	responses.Send(c, http.StatusOK, "Found results certificate", gin.H{
+		"certificate": signed,
+		"publicKey":   certificate.PublicKey(),
+	})
+}
+
+// VerifyCertificate checks a certificate's signature, and whether it is the one issued
+// for its poll
+func VerifyCertificate(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.VerifyCertificate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	results, err := certificate.Verify(req.Certificate)
+	if err != nil {
+		responses.Send(c, http.StatusOK, "Certificate is invalid", gin.H{
+			"valid":     false,
+			"reason":    err.Error(),
+			"publicKey": certificate.PublicKey(),
+		})
+		return
+	}
+
+	// A validly signed certificate can only differ from the issued one if the key signed
+	// something else for the poll, but checking costs nothing
+	var issued issuedCertificate
+	err = CertificatesColl.FindOne(ctx, bson.M{"_id": results.PollId}, options.FindOne()).Decode(&issued)
+	if err != nil && err != mongo.ErrNoDocuments {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find certificate", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Certificate is valid", gin.H{
+		"valid":     true,
+		"results":   results,
+		"issued":    err == nil && issued.Certificate.Payload == req.Certificate.Payload,
+		"publicKey": certificate.PublicKey(),
+	})
+}
@@ -0,0 +1,121 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"rapidvote/api/challenge"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// SpentChallengesColl holds the nonces of solved challenges until they expire
+	SpentChallengesColl *mongo.Collection = database.Mongo.Database("test").Collection("spent_challenges")
+)
+
+// errChallengeSpent is returned when casting a vote with a challenge solution used before
+var errChallengeSpent = errors.New("challenge solution was already used")
+
+// issueVoteChallenge creates the challenge an anonymous voter has to solve to vote on the
+// poll, with a proof-of-work difficulty that follows the poll's recent vote rate
+func issueVoteChallenge(ctx context.Context, poll models.Poll) (*challenge.Challenge, error) {
+	difficulty := 0
+	if poll.Challenge == challenge.KindPoW {
+		filter := bson.M{"pollId": poll.PollId, "castAt": bson.M{"$gte": time.Now().Add(-challenge.RateWindow)}}
+		recentVotes, err := ParticipationsColl.CountDocuments(ctx, filter, options.Count())
+		if err != nil {
+			return nil, err
+		}
+		difficulty = challenge.Difficulty(recentVotes)
+	}
+
+	issued, err := challenge.Issue(poll.Challenge, poll.PollId, difficulty)
+	if err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// checkVoteChallenge verifies the challenge solution sent with an anonymous vote. It is only
+// spent by spendChallenge as the vote is cast, so a vote turned away for another reason doesn't
+// cost the voter their solution. If ok is false, an error response has already been sent
+func checkVoteChallenge(c *gin.Context, ctx context.Context, poll models.Poll, token string, solution string) (*challenge.Solved, bool) {
+	if len(token) == 0 {
+		responses.Send(c, http.StatusForbidden, "This poll requires solving a challenge, view the poll to get one", gin.H{
+			"challenge": poll.Challenge,
+		})
+		return nil, false
+	}
+
+	solved, err := challenge.Verify(ctx, token, poll.Challenge, poll.PollId, solution, clientIP(c))
+	if err == challenge.ErrInvalid || err == challenge.ErrWrongKind || err == challenge.ErrUnsolved {
+		responses.Send(c, http.StatusForbidden, "Invalid or expired challenge solution", gin.H{
+			"reason": err.Error(),
+		})
+		return nil, false
+	} else if err != nil {
+		log.Printf("Couldn't verify challenge for poll [%s]: %s\n", poll.PollId, err.Error())
+		responses.Send(c, http.StatusBadGateway, "Couldn't verify challenge", gin.H{
+			"reason": err.Error(),
+		})
+		return nil, false
+	}
+
+	// Casting checks again with spendChallenge, this just turns most reused solutions away early
+	err = SpentChallengesColl.FindOne(ctx, bson.M{"_id": solved.Nonce}).Err()
+	if err == nil {
+		sendChallengeSpent(c)
+		return nil, false
+	} else if err != mongo.ErrNoDocuments {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return nil, false
+	}
+	return &solved, true
+}
+
+// spendChallenge spends the solution of a vote's challenge as the vote is cast, in the same
+// transaction. The nonce is the document ID, so a solution that was already used fails to
+// insert. Votes without a challenge have nothing to spend
+func spendChallenge(sc mongo.SessionContext, pollId string, solved *challenge.Solved) error {
+	if solved == nil {
+		return nil
+	}
+	_, err := SpentChallengesColl.InsertOne(sc, bson.M{"_id": solved.Nonce, "pollId": pollId, "expiresAt": solved.ExpiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return errChallengeSpent
+	}
+	return err
+}
+
+// sendChallengeSpent is the response to errChallengeSpent
+func sendChallengeSpent(c *gin.Context) {
+	responses.Send(c, http.StatusForbidden, "Challenge solution was already used", gin.H{})
+}
+
+// purgeSpentChallenges forgets spent nonces once their challenges have expired, since
+// expired challenges are rejected anyway
+func purgeSpentChallenges() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	result, err := SpentChallengesColl.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Printf("Couldn't purge spent challenges: %s\n", err.Error())
+		return
+	}
+	if result.DeletedCount > 0 {
+		log.Printf("Purged %d spent challenges\n", result.DeletedCount)
+	}
+}
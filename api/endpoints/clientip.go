@@ -0,0 +1,31 @@
+package endpoints
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// clientIP is the client's address as resolved by middleware.ClientIP
+func clientIP(c *gin.Context) string {
+	if ip := c.GetString("clientIP"); len(ip) > 0 {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+// clientAddr is the client's address grouped for rate limiting and deduplication, so
+// every address of an IPv6 prefix counts as the same client
+func clientAddr(c *gin.Context) string {
+	if addr := c.GetString("clientAddr"); len(addr) > 0 {
+		return addr
+	}
+	return c.ClientIP()
+}
+
+// clientIPChain is every address the request passed through, with the connecting peer last
+func clientIPChain(c *gin.Context) []string {
+	chain, _ := c.Get("clientIPChain")
+	if addresses, ok := chain.([]string); ok {
+		return addresses
+	}
+	return []string{c.ClientIP()}
+}
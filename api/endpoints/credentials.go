@@ -0,0 +1,256 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"rapidvote/api/blindsig"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	minNonceLength int = 16
+	maxNonceLength int = 256
+
+	ReasonCredentialRequired = "credential_required"
+	ReasonCredentialInvalid  = "credential_invalid"
+	ReasonCredentialSpent    = "credential_spent"
+	ReasonCredentialIssued   = "credential_issued"
+)
+
+var (
+	CredentialKeysColl      *mongo.Collection = database.Mongo.Database("test").Collection("credential_keys")
+	CredentialIssuancesColl *mongo.Collection = database.Mongo.Database("test").Collection("credential_issuances")
+	SpentCredentialsColl    *mongo.Collection = database.Mongo.Database("test").Collection("spent_credentials")
+)
+
+var (
+	errCredentialSpent       = errors.New("credential was already used")
+	errCredentialKeyNotFound = errors.New("poll has no credential key")
+)
+
+// newCredentialKey creates the key a poll's credentials are signed with, and returns its
+// public half for the poll
+func newCredentialKey(ctx context.Context, pollId string) (blindsig.PublicKey, error) {
+	private, public, err := blindsig.GenerateKey()
+	if err != nil {
+		return blindsig.PublicKey{}, err
+	}
+	_, err = CredentialKeysColl.InsertOne(ctx, models.CredentialKey{
+		PollId:     pollId,
+		PrivateKey: x509.MarshalPKCS1PrivateKey(private),
+		CreatedAt:  time.Now(),
+	})
+	return public, err
+}
+
+func credentialKey(ctx context.Context, poll models.Poll) (*rsa.PrivateKey, error) {
+	var key models.CredentialKey
+	err := CredentialKeysColl.FindOne(ctx, bson.M{"_id": poll.PollId}, options.FindOne()).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, errCredentialKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	private, err := x509.ParsePKCS1PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	// The key a voter blinds with is the one on the poll, anything else would be a way to
+	// tell voters apart
+	if blindsig.Public(private) != *poll.Credentials {
+		return nil, errors.New("credential key doesn't match the poll")
+	}
+	return private, nil
+}
+
+func credentialIssuanceId(pollId string, userId primitive.ObjectID) string {
+	return pollId + "/" + userId.Hex()
+}
+
+// spentCredentialId is what a spent credential is remembered by. The signature is the
+// same for every message, so the message alone is enough
+func spentCredentialId(pollId string, nonce string) string {
+	digest := sha256.Sum256(blindsig.Message(pollId, nonce))
+	return hex.EncodeToString(digest[:])
+}
+
+// IssueCredential blindly signs a logged in voter's credential for a poll. Each voter gets
+// one, and asking again with the same blinded message signs it again, in case the first
+// response was lost
+func IssueCredential(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.IssueCredential
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !checkPollAccess(c, poll, req.Grant) {
+		return
+	}
+	if poll.Credentials == nil {
+		responses.Send(c, http.StatusBadRequest, "Poll doesn't use anonymous credentials", gin.H{})
+		return
+	}
+	if isPollClosed(poll) {
+		responses.Send(c, http.StatusConflict, "Poll is closed", gin.H{})
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(requestUserId(c, ""))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Malformed user ID", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	var user models.User
+	if err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user); err != nil {
+		responses.Send(c, http.StatusBadRequest, "User does not exist", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if reason := ineligibility(c, poll, &user); len(reason) > 0 {
+		responses.Send(c, http.StatusForbidden, "You aren't eligible to vote on this poll", gin.H{
+			"reason": reason,
+		})
+		return
+	}
+
+	private, err := credentialKey(ctx, poll)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find credential key", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	signature, err := blindsig.Sign(private, req.Blinded)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Invalid blinded credential", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// The issuance ID is the pair of poll and user, so only one of two requests for the
+	// same voter gets to insert it
+	issuance := models.CredentialIssuance{
+		Id:       credentialIssuanceId(poll.PollId, userId),
+		PollId:   poll.PollId,
+		UserId:   userId,
+		Blinded:  req.Blinded,
+		IssuedAt: time.Now(),
+	}
+	_, err = CredentialIssuancesColl.InsertOne(ctx, issuance)
+	if mongo.IsDuplicateKeyError(err) {
+		var issued models.CredentialIssuance
+		err = CredentialIssuancesColl.FindOne(ctx, bson.M{"_id": issuance.Id}, options.FindOne()).Decode(&issued)
+		if err == nil && issued.Blinded != req.Blinded {
+			responses.Send(c, http.StatusForbidden, "You already got a credential for this poll", gin.H{
+				"reason": ReasonCredentialIssued,
+			})
+			return
+		}
+	}
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't issue credential", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Issued credential for poll [%s]\n", poll.PollId)
+	responses.Send(c, http.StatusOK, "Credential issued", gin.H{
+		"blindSignature": signature,
+		"key":            poll.Credentials,
+	})
+}
+
+// voteWithCredential casts a ballot on a poll with anonymous credentials. The credential
+// proves the voter was eligible without saying who they are, so there is no participation
+// record, only the spent credential
+func voteWithCredential(c *gin.Context, ctx context.Context, poll models.Poll, req requests.VotePoll) {
+	credential := req.Credential
+	if credential == nil {
+		responses.Send(c, http.StatusForbidden, "This poll requires a credential", gin.H{
+			"reason": ReasonCredentialRequired,
+		})
+		return
+	}
+	if len(credential.Nonce) < minNonceLength || len(credential.Nonce) > maxNonceLength ||
+		blindsig.Verify(*poll.Credentials, blindsig.Message(poll.PollId, credential.Nonce), credential.Signature) != nil {
+		responses.Send(c, http.StatusForbidden, "Credential is invalid", gin.H{
+			"reason": ReasonCredentialInvalid,
+		})
+		return
+	}
+	if !checkEncryptedBallot(c, ctx, poll, req.EncryptedBallot) {
+		return
+	}
+
+	// Spending the credential and casting the ballot happen together. The spent credential
+	// is only kept at the precision of a ballot's time bucket, so the two can't be matched
+	ballot := newBallot(poll, req.Choice, req.EncryptedBallot, primitive.NilObjectID, time.Now())
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		_, err := SpentCredentialsColl.InsertOne(sc, bson.M{
+			"_id":     spentCredentialId(poll.PollId, credential.Nonce),
+			"pollId":  poll.PollId,
+			"spentAt": ballot.CastAt,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return errCredentialSpent
+		} else if err != nil {
+			return err
+		}
+		if err = recordBallotFingerprint(sc, poll.PollId, ballot.Encrypted); err != nil {
+			return err
+		}
+		_, err = BallotsColl.InsertOne(sc, ballot)
+		return err
+	})
+	if err == errCredentialSpent {
+		responses.Send(c, http.StatusForbidden, "Credential was already used", gin.H{
+			"reason": ReasonCredentialSpent,
+		})
+		return
+	} else if err == errBallotCopied {
+		sendBallotCopied(c)
+		return
+	} else if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't cast vote", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	responses.Send(c, http.StatusOK, "Vote was cast", gin.H{
+		"receipt": ballotReceipt(ballot),
+	})
+}
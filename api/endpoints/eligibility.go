@@ -0,0 +1,116 @@
+package endpoints
+
+import (
+	"strings"
+	"time"
+
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Why someone can't vote on a poll, as reported by ViewPoll and VotePoll
+const (
+	ReasonLoginRequired    = "login_required"
+	ReasonDomainNotAllowed = "domain_not_allowed"
+	ReasonNotOnAllowlist   = "not_on_allowlist"
+	ReasonEmailNotVerified = "email_not_verified"
+	ReasonAccountTooNew    = "account_too_new"
+	ReasonAlreadyVoted     = "already_voted"
+)
+
+// newEligibility normalizes the rules sent with CreatePoll
+func newEligibility(req requests.Eligibility) *models.Eligibility {
+	eligibility := models.Eligibility{MinAccountAgeDays: req.MinAccountAgeDays}
+	for _, domain := range req.AllowedDomains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+		if len(domain) > 0 {
+			eligibility.AllowedDomains = append(eligibility.AllowedDomains, domain)
+		}
+	}
+	for _, voter := range req.AllowedVoters {
+		voter = strings.ToLower(strings.TrimSpace(voter))
+		if len(voter) > 0 {
+			eligibility.AllowedVoters = append(eligibility.AllowedVoters, voter)
+		}
+	}
+
+	if eligibility.IsEmpty() {
+		return nil
+	}
+	return &eligibility
+}
+
+// requiresLogin reports whether only logged in users may vote on the poll. Public ballots
+// need to know who voted, so they always do
+func requiresLogin(poll models.Poll) bool {
+	return poll.AuthRequired || dedupePolicy(poll) == models.DedupeAccount || poll.BallotSecrecy == models.BallotPublic ||
+		(poll.Eligibility != nil && !poll.Eligibility.IsEmpty())
+}
+
+// ineligibility returns why `user` can't vote on the poll, or an empty string if they can.
+// `user` is nil for anonymous voters. A user ID sent in the request body isn't proof of who
+// the voter is, so polls that require logging in only accept sessions and API tokens
+func ineligibility(c *gin.Context, poll models.Poll, user *models.User) string {
+	if !requiresLogin(poll) {
+		return ""
+	}
+	if _, authenticated := c.Get("accessClaims"); !authenticated || user == nil {
+		return ReasonLoginRequired
+	}
+
+	rules := poll.Eligibility
+	if rules == nil {
+		return ""
+	}
+
+	// Anyone can register or change to any email, so rules only go by the ones an identity
+	// provider verified
+	email := ""
+	if user.EmailVerified {
+		email = strings.ToLower(user.Email)
+	}
+
+	if len(rules.AllowedDomains) > 0 {
+		if len(email) == 0 {
+			return ReasonEmailNotVerified
+		}
+		domain := email[strings.LastIndex(email, "@")+1:]
+		allowed := false
+		for _, allowedDomain := range rules.AllowedDomains {
+			if domain == allowedDomain {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ReasonDomainNotAllowed
+		}
+	}
+
+	if len(rules.AllowedVoters) > 0 {
+		allowed := false
+		for _, voter := range rules.AllowedVoters {
+			if (len(email) > 0 && voter == email) || voter == user.Id.Hex() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed && len(email) == 0 {
+			return ReasonEmailNotVerified
+		} else if !allowed {
+			return ReasonNotOnAllowlist
+		}
+	}
+
+	// An account was created when its ID was
+	if rules.MinAccountAgeDays > 0 {
+		minAge := time.Duration(rules.MinAccountAgeDays) * 24 * time.Hour
+		if time.Since(user.Id.Timestamp()) < minAge {
+			return ReasonAccountTooNew
+		}
+	}
+
+	return ""
+}
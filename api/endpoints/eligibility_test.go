@@ -0,0 +1,96 @@
+//go:build integration
+// +build integration
+
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIneligibility(t *testing.T) {
+	byDomain := newEligibility(requests.Eligibility{AllowedDomains: []string{" @Example.com"}})
+	byList := newEligibility(requests.Eligibility{AllowedVoters: []string{"Ann@example.com ", "62213d7a3e6b5b0c9c7d1a01"}})
+	byAge := newEligibility(requests.Eligibility{MinAccountAgeDays: 7})
+
+	listedId, _ := primitive.ObjectIDFromHex("62213d7a3e6b5b0c9c7d1a01")
+	verified := &models.User{Id: primitive.NewObjectID(), Email: "ann@EXAMPLE.com", EmailVerified: true}
+	unverified := &models.User{Id: primitive.NewObjectID(), Email: "ann@example.com"}
+	elsewhere := &models.User{Id: primitive.NewObjectID(), Email: "bob@example.org", EmailVerified: true}
+	listedById := &models.User{Id: listedId}
+	old := &models.User{Id: primitive.NewObjectIDFromTimestamp(time.Now().Add(-8 * 24 * time.Hour))}
+	recent := &models.User{Id: primitive.NewObjectIDFromTimestamp(time.Now().Add(-6 * 24 * time.Hour))}
+
+	tests := []struct {
+		name  string
+		rules *models.Eligibility
+		user  *models.User
+		want  string
+	}{
+		{"anonymous", byDomain, nil, ReasonLoginRequired},
+		{"verified email in domain", byDomain, verified, ""},
+		{"unverified email in domain", byDomain, unverified, ReasonEmailNotVerified},
+		{"email in another domain", byDomain, elsewhere, ReasonDomainNotAllowed},
+		{"listed verified email", byList, verified, ""},
+		{"listed unverified email", byList, unverified, ReasonEmailNotVerified},
+		{"listed user ID", byList, listedById, ""},
+		{"unlisted verified email", byList, elsewhere, ReasonNotOnAllowlist},
+		{"account old enough", byAge, old, ""},
+		{"account too new", byAge, recent, ReasonAccountTooNew},
+	}
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if test.user != nil {
+			withSession(c, test.user.Id)
+		}
+		poll := models.Poll{PollId: "abcd1234", Eligibility: test.rules}
+		if reason := ineligibility(c, poll, test.user); reason != test.want {
+			t.Errorf("%s: ineligibility = %q, want %q", test.name, reason, test.want)
+		}
+	}
+
+	// A user looked up from an ID in the request body, without a session, is still anonymous
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if reason := ineligibility(c, models.Poll{Eligibility: byDomain}, verified); reason != ReasonLoginRequired {
+		t.Errorf("user without a session: ineligibility = %q, want %q", reason, ReasonLoginRequired)
+	}
+}
+
+func TestVoteEligibility(t *testing.T) {
+	requireMongo(t)
+	poll := createTestPoll(t, models.Poll{Eligibility: newEligibility(requests.Eligibility{AllowedDomains: []string{"example.com"}})})
+	unverified := createTestUser(t, models.User{Email: "unverified@example.com"})
+	verified := createTestUser(t, models.User{Email: "verified@example.com", EmailVerified: true})
+	vote := requests.VotePoll{PollId: poll.PollId, Choice: 0}
+
+	tests := []struct {
+		name   string
+		userId primitive.ObjectID
+		status int
+		reason string
+	}{
+		{"anonymous", primitive.NilObjectID, http.StatusUnauthorized, ReasonLoginRequired},
+		{"unverified email", unverified.Id, http.StatusForbidden, ReasonEmailNotVerified},
+		{"verified email", verified.Id, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		recorder := postJSON(VotePoll, test.userId, "192.0.2.1", vote)
+		if recorder.Code != test.status {
+			t.Errorf("%s: vote = %d, want %d: %s", test.name, recorder.Code, test.status, recorder.Body.String())
+			continue
+		}
+		if len(test.reason) > 0 {
+			if reason := responseMetadata(t, recorder)["reason"]; reason != test.reason {
+				t.Errorf("%s: reason = %v, want %q", test.name, reason, test.reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Exports of up to this many polls and votes are built while the client waits,
+	// anything larger becomes a background job
+	syncExportLimit int64 = 200
+
+	exportLifetime time.Duration = 48 * time.Hour
+)
+
+var (
+	ExportsColl *mongo.Collection = database.Mongo.Database("test").Collection("exports")
+
+	exportDir = exportDirectory()
+)
+
+func exportDirectory() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "rapidvote-exports")
+}
+
+type exportedPoll struct {
+	Poll       models.Poll
+	Count      map[int]int64
+	TotalVotes int64
+}
+
+// exportedVote is a participation of the user. The choice is only known for public
+// ballots and for votes still waiting for review, whose held ballot is still referenced
+type exportedVote struct {
+	Vote       models.Participation
+	Choice     *uint
+	PollName   string
+	ChoiceText string
+}
+
+// ExportData builds an archive of everything held about the user: their profile, the polls
+// they created with full results and the votes they cast. Small exports are sent right away,
+// larger ones are built in the background and fetched with ExportStatus and DownloadExport
+func ExportData(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	pollCount, err := PollsColl.CountDocuments(ctx, bson.M{"creator": userId}, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	voteCount, err := ParticipationsColl.CountDocuments(ctx, bson.M{"voterId": userId}, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count votes", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	if pollCount+voteCount <= syncExportLimit {
+		var archive bytes.Buffer
+		if err := writeExport(ctx, userId, &archive); err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't export data", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		log.Printf("Exported data of user %s\n", userId.Hex())
+
+		c.Header("Content-Disposition", `attachment; filename="rapidvote-export.zip"`)
+		c.Data(http.StatusOK, "application/zip", archive.Bytes())
+		return
+	}
+
+	export := models.Export{
+		Owner:     userId,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(exportLifetime),
+	}
+	result, err := ExportsColl.InsertOne(ctx, export)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't start export", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	export.Id = result.InsertedID.(primitive.ObjectID)
+	log.Printf("Started export %s for user %s\n", export.Id.Hex(), userId.Hex())
+
+	go runExport(export)
+
+	responses.Send(c, http.StatusAccepted, "Export started", gin.H{
+		"exportId": export.Id,
+	})
+}
+
+func runExport(export models.Export) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var update bson.M
+	path := filepath.Join(exportDir, export.Id.Hex()+".zip")
+	size, err := writeExportFile(ctx, export.Owner, path)
+	now := time.Now()
+	if err != nil {
+		log.Printf("Export %s failed: %s\n", export.Id.Hex(), err.Error())
+		os.Remove(path)
+		update = bson.M{"status": models.ExportFailed, "error": err.Error(), "completedAt": now}
+	} else {
+		log.Printf("Export %s is ready\n", export.Id.Hex())
+		update = bson.M{"status": models.ExportReady, "path": path, "size": size, "completedAt": now}
+	}
+
+	_, err = ExportsColl.UpdateOne(ctx, bson.M{"_id": export.Id}, bson.M{"$set": update})
+	if err != nil {
+		log.Printf("Couldn't update export %s: %s\n", export.Id.Hex(), err.Error())
+	}
+}
+
+func writeExportFile(ctx context.Context, userId primitive.ObjectID, path string) (int64, error) {
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if err = writeExport(ctx, userId, file); err != nil {
+		return 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// writeExport writes the user's data as a zip archive with a JSON and a CSV version of it
+func writeExport(ctx context.Context, userId primitive.ObjectID, w io.Writer) error {
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		return err
+	}
+
+	tokens := []models.APIToken{}
+	cursor, err := TokensColl.Find(ctx, bson.M{"owner": userId}, options.Find())
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &tokens); err != nil {
+		return err
+	}
+
+	// Every poll the user created, with its results
+	var createdPolls []models.Poll
+	cursor, err = PollsColl.Find(ctx, bson.M{"creator": userId}, options.Find())
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &createdPolls); err != nil {
+		return err
+	}
+
+	polls := []exportedPoll{}
+	for _, poll := range createdPolls {
+		// Like everyone else, the creator only gets exact counts of private polls once closed
+		if poll.Privacy != nil && !isPollClosed(poll) {
+			polls = append(polls, exportedPoll{Poll: poll})
+			continue
+		}
+		count, err := countVotes(ctx, poll)
+		if err != nil && err != errNotDecrypted {
+			return err
+		}
+		total := int64(0)
+		for _, optionCount := range count {
+			total += optionCount
+		}
+		polls = append(polls, exportedPoll{Poll: poll, Count: count, TotalVotes: total})
+	}
+
+	// Every vote the user cast, with the poll it was cast on
+	var participations []models.Participation
+	cursor, err = ParticipationsColl.Find(ctx, bson.M{"voterId": userId}, options.Find())
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &participations); err != nil {
+		return err
+	}
+
+	votes := []exportedVote{}
+	for _, participation := range participations {
+		exported := exportedVote{Vote: participation}
+		var ballot models.Ballot
+		var err error
+		if len(participation.HeldBallot) > 0 {
+			ballot, err = findHeldBallot(ctx, participation.PollId, participation.HeldBallot)
+		} else {
+			filter := bson.M{"pollId": participation.PollId, "voterId": userId}
+			err = BallotsColl.FindOne(ctx, filter, options.FindOne()).Decode(&ballot)
+		}
+		if err == nil && ballot.Encrypted == nil {
+			exported.Choice = &ballot.Choice
+		} else if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		var poll models.Poll
+		err = PollsColl.FindOne(ctx, bson.M{"pollId": participation.PollId}, options.FindOne()).Decode(&poll)
+		if err == nil {
+			exported.PollName = poll.Name
+			if exported.Choice != nil && int(*exported.Choice) < len(poll.Options) {
+				exported.ChoiceText = poll.Options[*exported.Choice]
+			}
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+		votes = append(votes, exported)
+	}
+
+	archive := zip.NewWriter(w)
+
+	jsonFiles := map[string]interface{}{
+		"profile.json": gin.H{"user": user, "apiTokens": tokens},
+		"polls.json":   polls,
+		"votes.json":   votes,
+	}
+	for name, data := range jsonFiles {
+		f, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(data); err != nil {
+			return err
+		}
+	}
+
+	pollRows := [][]string{{"pollId", "name", "description", "open", "expiration", "option", "votes"}}
+	for _, p := range polls {
+		for optionIndex, option := range p.Poll.Options {
+			pollRows = append(pollRows, []string{
+				p.Poll.PollId,
+				p.Poll.Name,
+				p.Poll.Description,
+				strconv.FormatBool(p.Poll.Status),
+				p.Poll.Expiration.Format(time.RFC3339),
+				option,
+				strconv.FormatInt(p.Count[optionIndex], 10),
+			})
+		}
+	}
+
+	voteRows := [][]string{{"pollId", "pollName", "choice", "choiceText", "voterAddr"}}
+	for _, v := range votes {
+		choice := ""
+		if v.Choice != nil {
+			choice = fmt.Sprint(*v.Choice)
+		}
+		voteRows = append(voteRows, []string{
+			v.Vote.PollId,
+			v.PollName,
+			choice,
+			v.ChoiceText,
+			v.Vote.VoterAddr,
+		})
+	}
+
+	csvFiles := map[string][][]string{
+		"polls.csv": pollRows,
+		"votes.csv": voteRows,
+	}
+	for name, rows := range csvFiles {
+		f, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		if err = csv.NewWriter(f).WriteAll(rows); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func ExportStatus(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.ExportStatus
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	export, ok := findExport(c, ctx, req.ExportId, userId)
+	if !ok {
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found export", gin.H{
+		"export": export,
+	})
+}
+
+func DownloadExport(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	export, ok := findExport(c, ctx, c.Params.ByName("exportId"), userId)
+	if !ok {
+		return
+	}
+	if export.Status != models.ExportReady {
+		responses.Send(c, http.StatusConflict, "Export isn't ready", gin.H{
+			"status": export.Status,
+		})
+		return
+	}
+
+	c.FileAttachment(export.Path, "rapidvote-export.zip")
+}
+
+// findExport finds one of the user's exports that hasn't expired yet. If it returns
+// false, an error response has already been sent
+func findExport(c *gin.Context, ctx context.Context, exportId string, userId primitive.ObjectID) (models.Export, bool) {
+	id, err := primitive.ObjectIDFromHex(exportId)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Malformed export ID", gin.H{
+			"reason": err.Error(),
+		})
+		return models.Export{}, false
+	}
+
+	var export models.Export
+	filter := bson.M{"_id": id, "owner": userId, "expiresAt": bson.M{"$gt": time.Now()}}
+	err = ExportsColl.FindOne(ctx, filter, options.FindOne()).Decode(&export)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Export not found", gin.H{})
+		return models.Export{}, false
+	}
+	return export, true
+}
+
+// purgeExpiredExports deletes exports, and their archives, once they expire
+func purgeExpiredExports() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"expiresAt": bson.M{"$lte": time.Now()}}
+	cursor, err := ExportsColl.Find(ctx, filter, options.Find())
+	if err != nil {
+		log.Printf("Couldn't find expired exports: %s\n", err.Error())
+		return
+	}
+
+	var exports []models.Export
+	if err = cursor.All(ctx, &exports); err != nil {
+		log.Printf("Couldn't parse expired exports: %s\n", err.Error())
+		return
+	}
+
+	for _, export := range exports {
+		if len(export.Path) > 0 {
+			if err := os.Remove(export.Path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Couldn't remove export %s: %s\n", export.Id.Hex(), err.Error())
+				continue
+			}
+		}
+		if _, err := ExportsColl.DeleteOne(ctx, bson.M{"_id": export.Id}); err != nil {
+			log.Printf("Couldn't delete export %s: %s\n", export.Id.Hex(), err.Error())
+		}
+	}
+}
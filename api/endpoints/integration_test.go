@@ -0,0 +1,110 @@
+//go:build integration
+// +build integration
+
+package endpoints
+
+// The endpoint tests need MONGODB_URI set before the package loads, since its collections
+// connect as it does. Tests that read or write are skipped when the database doesn't answer.
+// Votes are cast in transactions, so point it at a throwaway replica set:
+//
+//	MONGODB_URI='mongodb://localhost:27017/?replicaSet=rs0' go test -tags integration ./endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// requireMongo skips the test unless the database answers
+func requireMongo(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := database.Mongo.Ping(ctx, nil); err != nil {
+		t.Skipf("no MongoDB to test against: %s", err)
+	}
+}
+
+// createTestPoll stores an open poll with two options, and deletes it along with everything
+// cast on it once the test is done
+func createTestPoll(t *testing.T, poll models.Poll) models.Poll {
+	t.Helper()
+	poll.PollId = util.GenSecureRandomString(12)
+	poll.Options = []string{"yes", "no"}
+	poll.Status = true
+	poll.Expiration = time.Now().Add(time.Hour)
+	if _, err := PollsColl.InsertOne(context.Background(), poll); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, _, err := deletePollData(context.Background(), []interface{}{poll.PollId}); err != nil {
+			t.Errorf("couldn't delete test poll: %s", err)
+		}
+	})
+	return poll
+}
+
+// createTestUser stores a user, and deletes them once the test is done
+func createTestUser(t *testing.T, user models.User) models.User {
+	t.Helper()
+	result, err := UsersColl.InsertOne(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Id = result.InsertedID.(primitive.ObjectID)
+	t.Cleanup(func() {
+		UsersColl.DeleteOne(context.Background(), bson.M{"_id": user.Id})
+	})
+	return user
+}
+
+// withSession makes the request's caller `userId`, as middleware.OptionalAuth does for a
+// session. A zero ID leaves the caller anonymous
+func withSession(c *gin.Context, userId primitive.ObjectID) {
+	if !userId.IsZero() {
+		c.Set("accessClaims", &auth.Claims{StandardClaims: jwt.StandardClaims{Issuer: userId.Hex()}})
+	}
+}
+
+// postJSON sends `body` to the handler from `remoteAddr`, as `userId`
+func postJSON(handler gin.HandlerFunc, userId primitive.ObjectID, remoteAddr string, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/", func(c *gin.Context) { withSession(c, userId) }, handler)
+
+	encoded, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr + ":4711"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// responseMetadata is the metadata of a response sent with responses.Send
+func responseMetadata(t *testing.T, recorder *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var response struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response isn't JSON: %s", recorder.Body.String())
+	}
+	return response.Metadata
+}
@@ -0,0 +1,394 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/mailer"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DeliveryEmail = "email"
+	DeliveryCSV   = "csv"
+
+	maxInvitationsPerUpload int = 5000
+
+	ReasonInvitationRequired = "invitation_required"
+	ReasonInvitationInvalid  = "invitation_invalid"
+)
+
+var (
+	InvitationsColl *mongo.Collection = database.Mongo.Database("test").Collection("invitations")
+
+	// appURL is where the frontend is served, for links sent to voters
+	appURL = appBaseURL()
+)
+
+func appBaseURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:3000"
+}
+
+func invitationLink(pollId string, token string) string {
+	return appURL + "/" + pollId + "?invitation=" + token
+}
+
+// findCreatedPoll finds a poll the authenticated user created. If it returns false, an
+// error response has already been sent
+func findCreatedPoll(c *gin.Context, ctx context.Context, pollId string) (models.Poll, bool) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return models.Poll{}, false
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": pollId, "creator": userId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return models.Poll{}, false
+	}
+	return poll, true
+}
+
+// bindInviteVoters reads the voter list either as JSON, or as a multipart form with a
+// `voters` CSV file of `email,name` rows next to `pollId` and `delivery` fields
+func bindInviteVoters(c *gin.Context, req *requests.InviteVoters) error {
+	if c.ContentType() != "multipart/form-data" {
+		return c.ShouldBindJSON(req)
+	}
+
+	req.PollId = c.PostForm("pollId")
+	req.Delivery = c.PostForm("delivery")
+
+	header, err := c.FormFile("voters")
+	if err != nil {
+		return err
+	}
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(record) == 0 || strings.EqualFold(strings.TrimSpace(record[0]), "email") {
+			continue
+		}
+		voter := requests.InvitedVoter{Email: record[0]}
+		if len(record) > 1 {
+			voter.Name = record[1]
+		}
+		req.Voters = append(req.Voters, voter)
+	}
+	return nil
+}
+
+// InviteVoters adds voters to an invitation-only poll. Each of them gets a single-use token,
+// which is either emailed to them as a link or handed back to the creator as a CSV file.
+// Tokens are only ever shown here, since just their hashes are stored
+func InviteVoters(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var req requests.InviteVoters
+	if err := bindInviteVoters(c, &req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse voter list", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	if req.Delivery != DeliveryEmail && req.Delivery != DeliveryCSV {
+		responses.Send(c, http.StatusBadRequest, "Unknown delivery", gin.H{
+			"delivery": req.Delivery,
+		})
+		return
+	}
+	if len(req.Voters) == 0 || len(req.Voters) > maxInvitationsPerUpload {
+		responses.Send(c, http.StatusBadRequest, "Voter list must have between 1 and 5000 voters", gin.H{})
+		return
+	}
+
+	poll, ok := findCreatedPoll(c, ctx, req.PollId)
+	if !ok {
+		return
+	}
+	if !poll.InviteOnly {
+		responses.Send(c, http.StatusBadRequest, "Poll isn't invitation-only", gin.H{})
+		return
+	}
+
+	// Voters that were already invited keep their existing invitation
+	invited, err := InvitationsColl.Distinct(ctx, "email", bson.M{"pollId": poll.PollId})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find invitations", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	seen := make(map[string]bool)
+	for _, email := range invited {
+		if e, ok := email.(string); ok {
+			seen[e] = true
+		}
+	}
+
+	var fieldErrors []responses.FieldError
+	var invitations []interface{}
+	var tokens []string
+	skipped := 0
+	now := time.Now()
+	for _, voter := range req.Voters {
+		address, err := mail.ParseAddress(strings.TrimSpace(voter.Email))
+		if err != nil {
+			fieldErrors = append(fieldErrors, responses.FieldError{
+				Field:   "voters",
+				Message: "Invalid email: " + voter.Email,
+			})
+			continue
+		}
+		email := strings.ToLower(address.Address)
+		if seen[email] {
+			skipped++
+			continue
+		}
+		seen[email] = true
+
+		token, hash := auth.GenerateInvitationToken()
+		invitations = append(invitations, models.Invitation{
+			PollId:    poll.PollId,
+			Email:     email,
+			Name:      strings.TrimSpace(voter.Name),
+			TokenHash: hash,
+			CreatedAt: now,
+		})
+		tokens = append(tokens, token)
+	}
+	if len(fieldErrors) > 0 {
+		responses.SendFieldErrors(c, "Invalid voter list", fieldErrors)
+		return
+	}
+
+	if len(invitations) > 0 {
+		if _, err = InvitationsColl.InsertMany(ctx, invitations); err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't create invitations", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+	}
+	log.Printf("Invited %d voters to poll [%s]\n", len(invitations), poll.PollId)
+
+	if req.Delivery == DeliveryCSV {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		writer.Write([]string{"email", "name", "link", "token"})
+		for i, doc := range invitations {
+			invitation := doc.(models.Invitation)
+			writer.Write([]string{invitation.Email, invitation.Name, invitationLink(poll.PollId, tokens[i]), tokens[i]})
+		}
+		writer.Flush()
+
+		c.Header("Content-Disposition", `attachment; filename="invitations-`+poll.PollId+`.csv"`)
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+		return
+	}
+
+	sent := 0
+	var failed []string
+	for i, doc := range invitations {
+		invitation := doc.(models.Invitation)
+		body := "You have been invited to vote on \"" + poll.Name + "\".\n\n" +
+			"Use this link to cast your vote. It only works once, so don't share it:\n" +
+			invitationLink(poll.PollId, tokens[i]) + "\n"
+		if err := mailer.Send(invitation.Email, "Your invitation to vote: "+poll.Name, body); err != nil {
+			log.Printf("Couldn't send invitation for poll [%s] to %s: %s\n", poll.PollId, invitation.Email, err.Error())
+			failed = append(failed, invitation.Email)
+			continue
+		}
+		sent++
+		InvitationsColl.UpdateOne(ctx, bson.M{"pollId": poll.PollId, "email": invitation.Email},
+			bson.M{"$set": bson.M{"sentAt": time.Now()}})
+	}
+
+	responses.Send(c, http.StatusOK, "Invited voters", gin.H{
+		"invited": len(invitations),
+		"skipped": skipped,
+		"sent":    sent,
+		"failed":  failed,
+	})
+}
+
+// ListInvitations shows the creator who was invited and the poll's turnout
+func ListInvitations(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.PollInvitations
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	poll, ok := findCreatedPoll(c, ctx, req.PollId)
+	if !ok {
+		return
+	}
+
+	cursor, err := InvitationsColl.Find(ctx, bson.M{"pollId": poll.PollId}, options.Find().SetSort(bson.M{"email": 1}))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find invitations", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	invitations := []models.Invitation{}
+	if err = cursor.All(ctx, &invitations); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse invitations", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// While the poll is open the creator could match each invitation being used with the
+	// results changing, so only the turnout is shown until it closes
+	closed := isPollClosed(poll)
+	listed := make([]listedInvitation, len(invitations))
+	voted := 0
+	for i, invitation := range invitations {
+		listed[i].Invitation = invitation
+		if closed {
+			used := invitation.Used
+			listed[i].Used = &used
+		}
+		if invitation.Used {
+			voted++
+		}
+	}
+	turnout := 0.0
+	if len(invitations) > 0 {
+		turnout = float64(voted) / float64(len(invitations))
+	}
+
+	responses.Send(c, http.StatusOK, "Found invitations", gin.H{
+		"invitations": listed,
+		"invited":     len(invitations),
+		"voted":       voted,
+		"turnout":     turnout,
+	})
+}
+
+// listedInvitation is an invitation as ListInvitations shows it, with Used left out while
+// the poll is open
+type listedInvitation struct {
+	models.Invitation
+	Used *bool `json:"Used,omitempty"`
+}
+
+// invitationIneligibility is why the holder of `token` can't vote on the invitation-only
+// poll, or an empty string if they can
+func invitationIneligibility(ctx context.Context, poll models.Poll, token string) (string, error) {
+	if len(token) == 0 {
+		return ReasonInvitationRequired, nil
+	}
+
+	var invitation models.Invitation
+	filter := bson.M{"pollId": poll.PollId, "tokenHash": auth.HashInvitationToken(token)}
+	err := InvitationsColl.FindOne(ctx, filter, options.FindOne()).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		return ReasonInvitationInvalid, nil
+	} else if err != nil {
+		return "", err
+	}
+	if invitation.Used {
+		return ReasonAlreadyVoted, nil
+	}
+	return "", nil
+}
+
+// voteWithInvitation casts a vote on an invitation-only poll, spending the invitation. The
+// spent invitation is the participation, and the ballot carries nothing that links it back
+// to the invitation or the voter
+func voteWithInvitation(c *gin.Context, ctx context.Context, poll models.Poll, req requests.VotePoll) {
+	if len(req.Invitation) == 0 {
+		responses.Send(c, http.StatusForbidden, "This poll requires an invitation", gin.H{
+			"reason": ReasonInvitationRequired,
+		})
+		return
+	}
+
+	if !checkEncryptedBallot(c, ctx, poll, req.EncryptedBallot) {
+		return
+	}
+
+	// Spending the invitation and casting the ballot happen together, and only one of two
+	// requests with the same invitation can spend it
+	spent := false
+	ballot := newBallot(poll, req.Choice, req.EncryptedBallot, primitive.NilObjectID, time.Now())
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		filter := bson.M{"pollId": poll.PollId, "tokenHash": auth.HashInvitationToken(req.Invitation), "used": false}
+		result, err := InvitationsColl.UpdateOne(sc, filter, bson.M{"$set": bson.M{"used": true}})
+		if err != nil {
+			return err
+		}
+		spent = result.ModifiedCount == 1
+		if !spent {
+			return nil
+		}
+		if err = recordBallotFingerprint(sc, poll.PollId, ballot.Encrypted); err != nil {
+			return err
+		}
+		_, err = BallotsColl.InsertOne(sc, ballot)
+		return err
+	})
+	if err == errBallotCopied {
+		sendBallotCopied(c)
+		return
+	} else if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't cast vote", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if !spent {
+		responses.Send(c, http.StatusForbidden, "Invitation is invalid or was already used", gin.H{
+			"reason": ReasonInvitationInvalid,
+		})
+		return
+	}
+	responses.Send(c, http.StatusOK, "Vote was cast", gin.H{
+		"receipt": ballotReceipt(ballot),
+	})
+}
@@ -0,0 +1,128 @@
+//go:build integration
+// +build integration
+
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// inviteTestVoter invites `email` to the poll, and returns the token of their link
+func inviteTestVoter(t *testing.T, poll models.Poll, email string) string {
+	t.Helper()
+	token, hash := auth.GenerateInvitationToken()
+	invitation := models.Invitation{PollId: poll.PollId, Email: email, TokenHash: hash, CreatedAt: time.Now()}
+	if _, err := InvitationsColl.InsertOne(context.Background(), invitation); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInvitationSpentOnce(t *testing.T) {
+	requireMongo(t)
+	poll := createTestPoll(t, models.Poll{InviteOnly: true})
+	token := inviteTestVoter(t, poll, "ann@example.com")
+
+	for _, invitation := range []string{"", "not-a-token"} {
+		vote := requests.VotePoll{PollId: poll.PollId, Choice: 0, Invitation: invitation}
+		if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote); recorder.Code != http.StatusForbidden {
+			t.Errorf("vote with invitation %q = %d, want %d", invitation, recorder.Code, http.StatusForbidden)
+		}
+	}
+
+	// However many requests spend the invitation at once, only one ballot is cast with it
+	const voters = 8
+	codes := make([]int, voters)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vote := requests.VotePoll{PollId: poll.PollId, Choice: uint(i % 2), Invitation: token}
+			codes[i] = postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote).Code
+		}(i)
+	}
+	wg.Wait()
+
+	cast := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			cast++
+		} else if code != http.StatusForbidden {
+			t.Errorf("vote with a spent invitation = %d, want %d", code, http.StatusForbidden)
+		}
+	}
+	if cast != 1 {
+		t.Errorf("%d of %d votes sent at once with one invitation were cast, want 1: %v", cast, voters, codes)
+	}
+	count, err := BallotsColl.CountDocuments(context.Background(), bson.M{"pollId": poll.PollId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d ballots recorded, want 1", count)
+	}
+
+	vote := requests.VotePoll{PollId: poll.PollId, Choice: 0, Invitation: token}
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.2", vote); recorder.Code != http.StatusForbidden {
+		t.Errorf("vote with the invitation afterwards = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+}
+
+func TestListInvitationsHidesUsedUntilClosed(t *testing.T) {
+	requireMongo(t)
+	creator := createTestUser(t, models.User{Email: "creator@example.com"})
+	poll := createTestPoll(t, models.Poll{InviteOnly: true, Creator: creator.Id})
+	token := inviteTestVoter(t, poll, "ann@example.com")
+	inviteTestVoter(t, poll, "bob@example.com")
+
+	vote := requests.VotePoll{PollId: poll.PollId, Choice: 0, Invitation: token}
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote); recorder.Code != http.StatusOK {
+		t.Fatalf("vote with the invitation = %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	list := func() []interface{} {
+		recorder := postJSON(ListInvitations, creator.Id, "192.0.2.1", requests.PollInvitations{PollId: poll.PollId})
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("list invitations = %d: %s", recorder.Code, recorder.Body.String())
+		}
+		metadata := responseMetadata(t, recorder)
+		if voted := metadata["voted"]; voted != 1.0 {
+			t.Errorf("voted = %v, want 1", voted)
+		}
+		return metadata["invitations"].([]interface{})
+	}
+
+	for _, invitation := range list() {
+		if used, shown := invitation.(map[string]interface{})["Used"]; shown {
+			t.Errorf("open poll shows an invitation's Used as %v", used)
+		}
+	}
+
+	if _, err := PollsColl.UpdateOne(context.Background(), bson.M{"pollId": poll.PollId}, bson.M{"$set": bson.M{"status": false}}); err != nil {
+		t.Fatal(err)
+	}
+	used := map[string]interface{}{}
+	for _, invitation := range list() {
+		invitation := invitation.(map[string]interface{})
+		used[invitation["Email"].(string)] = invitation["Used"]
+	}
+	if used["ann@example.com"] != true || used["bob@example.com"] != false {
+		t.Errorf("closed poll shows invitations used as %v, want only ann@example.com's", used)
+	}
+
+	if recorder := postJSON(ListInvitations, primitive.NewObjectID(), "192.0.2.1", requests.PollInvitations{PollId: poll.PollId}); recorder.Code != http.StatusNotFound {
+		t.Errorf("list invitations as someone else = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
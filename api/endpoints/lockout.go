@@ -0,0 +1,157 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Failed attempts allowed before a key gets locked out. An IP gets more
+	// attempts than an account since several people can share one address
+	accountFreeAttempts int = 5
+	ipFreeAttempts      int = 20
+
+	// Every failure past the free attempts doubles the lockout, up to maxLockout
+	baseLockout time.Duration = 30 * time.Second
+	maxLockout  time.Duration = time.Hour
+
+	// Failures older than this are forgotten
+	failureWindow time.Duration = 24 * time.Hour
+)
+
+var (
+	LoginAttemptsColl *mongo.Collection = database.Mongo.Database("test").Collection("login_attempts")
+
+	// Compared against when no account (or no password) exists for an email, so that
+	// unknown emails take about as long to reject as wrong passwords
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("rapidvote-dummy-password"), saltRounds)
+)
+
+func accountAttemptsKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptsKey(ip string) string {
+	return "ip:" + ip
+}
+
+func lockoutFor(failures int, freeAttempts int) time.Duration {
+	if failures <= freeAttempts {
+		return 0
+	}
+	exponent := float64(failures - freeAttempts - 1)
+	lockout := time.Duration(float64(baseLockout) * math.Pow(2, exponent))
+	if lockout > maxLockout || lockout <= 0 {
+		return maxLockout
+	}
+	return lockout
+}
+
+// loginLockedUntil returns the latest lockout among the given keys, or the zero time
+// if none of them is locked out
+func loginLockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	filter := bson.M{
+		"key":         bson.M{"$in": keys},
+		"lockedUntil": bson.M{"$gt": time.Now()},
+	}
+	findOptions := options.FindOne().SetSort(bson.M{"lockedUntil": -1})
+
+	var attempts models.LoginAttempts
+	err := LoginAttemptsColl.FindOne(ctx, filter, findOptions).Decode(&attempts)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return attempts.LockedUntil, nil
+}
+
+// recordLoginFailure counts a failed attempt for the key and locks it out once it has
+// used up its free attempts
+func recordLoginFailure(ctx context.Context, key string, freeAttempts int) error {
+	now := time.Now()
+
+	// Start counting from scratch if the last failure is old enough
+	_, err := LoginAttemptsColl.DeleteOne(ctx, bson.M{
+		"key":         key,
+		"lastFailure": bson.M{"$lt": now.Add(-failureWindow)},
+	})
+	if err != nil {
+		return err
+	}
+
+	var attempts models.LoginAttempts
+	err = LoginAttemptsColl.FindOneAndUpdate(ctx, bson.M{"key": key}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailure": now},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&attempts)
+	if err != nil {
+		return err
+	}
+
+	lockout := lockoutFor(attempts.Failures, freeAttempts)
+	if lockout == 0 {
+		return nil
+	}
+
+	log.Printf("Locking out %s for %s after %d failed logins\n", key, lockout, attempts.Failures)
+	_, err = LoginAttemptsColl.UpdateOne(ctx, bson.M{"_id": attempts.Id}, bson.M{
+		"$set": bson.M{"lockedUntil": now.Add(lockout)},
+	})
+	return err
+}
+
+// recordLoginFailures counts a failed attempt against both the account and the client IP
+func recordLoginFailures(ctx context.Context, email string, ip string) {
+	if err := recordLoginFailure(ctx, accountAttemptsKey(email), accountFreeAttempts); err != nil {
+		log.Printf("Couldn't record failed login for %s: %s\n", email, err.Error())
+	}
+	if err := recordLoginFailure(ctx, ipAttemptsKey(ip), ipFreeAttempts); err != nil {
+		log.Printf("Couldn't record failed login for %s: %s\n", ip, err.Error())
+	}
+}
+
+// resetLoginFailures forgets the failures of an account after a successful login. The
+// IP's failures are kept, so logging into one account can't reset attempts on others
+func resetLoginFailures(ctx context.Context, email string) {
+	_, err := LoginAttemptsColl.DeleteOne(ctx, bson.M{"key": accountAttemptsKey(email)})
+	if err != nil {
+		log.Printf("Couldn't reset failed logins for %s: %s\n", email, err.Error())
+	}
+}
+
+// checkLoginLockout sends a 429 and returns false if the account or the client IP is locked out
+func checkLoginLockout(c *gin.Context, ctx context.Context, email string) bool {
+	lockedUntil, err := loginLockedUntil(ctx, accountAttemptsKey(email), ipAttemptsKey(clientAddr(c)))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return false
+	}
+	if lockedUntil.IsZero() {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	c.Header("Retry-After", fmt.Sprint(retryAfter))
+	responses.Send(c, http.StatusTooManyRequests, "Too many failed login attempts", gin.H{
+		"retryAfter": retryAfter,
+	})
+	return false
+}
@@ -0,0 +1,222 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const oidcStateCookie = "oidcState"
+
+// OIDCLogin starts an authorization-code flow with PKCE. The client is sent the provider's
+// authorization URL, and keeps the signed state in a cookie until OIDCCallback
+func OIDCLogin(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := auth.OIDCProviders[strings.ToLower(c.Params.ByName("provider"))]
+	if !ok {
+		responses.Send(c, http.StatusNotFound, "Unknown identity provider", gin.H{})
+		return
+	}
+
+	stateToken, state, err := auth.GenerateOIDCState(provider.Name)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't generate OIDC state", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state)
+	if err != nil {
+		log.Printf("Couldn't build authorization URL for %s: %s\n", provider.Name, err.Error())
+		responses.Send(c, http.StatusBadGateway, "Couldn't reach identity provider", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// TODO: Change the domain in production, and set secure to true
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, stateToken, 600, "/", "", false, true)
+
+	responses.Send(c, http.StatusOK, "Redirect to identity provider", gin.H{
+		"authorizationUrl": authURL,
+	})
+}
+
+// OIDCCallback finishes the flow started by OIDCLogin. The frontend forwards the `code` and
+// `state` it was redirected back with, and gets the same session LoginUser would send.
+// If the request already carries a valid accessToken, the identity is linked to that user
+func OIDCCallback(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := auth.OIDCProviders[strings.ToLower(c.Params.ByName("provider"))]
+	if !ok {
+		responses.Send(c, http.StatusNotFound, "Unknown identity provider", gin.H{})
+		return
+	}
+
+	var req requests.OIDCCallback
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// The state cookie can only be used once
+	cookie, _ := c.Request.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/", "", false, true)
+	if cookie == nil || cookie.Value == "" {
+		responses.Send(c, http.StatusBadRequest, "Login session not found", gin.H{
+			"reason": "oidcState cookie nil or undefined",
+		})
+		return
+	}
+
+	state, err := auth.ParseOIDCState(cookie.Value)
+	if err != nil || state.Id != req.State || state.Provider != provider.Name {
+		responses.Send(c, http.StatusBadRequest, "Invalid login state", gin.H{})
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, req.Code, state.Verifier)
+	if err != nil {
+		log.Printf("Couldn't exchange code with %s: %s\n", provider.Name, err.Error())
+		responses.Send(c, http.StatusUnauthorized, "Couldn't exchange authorization code", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	idClaims, err := provider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		log.Printf("Couldn't verify ID token from %s: %s\n", provider.Name, err.Error())
+		responses.Send(c, http.StatusUnauthorized, "Couldn't verify ID token", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	log.Printf("Verified ID token from %s for subject %s\n", provider.Name, idClaims.Subject)
+
+	identity := models.Identity{
+		Provider: provider.Name,
+		Subject:  idClaims.Subject,
+	}
+	identityFilter := bson.M{"identities": bson.M{"$elemMatch": bson.M{
+		"provider": identity.Provider,
+		"subject":  identity.Subject,
+	}}}
+
+	// Find the user this identity is already linked to
+	var user models.User
+	err = UsersColl.FindOne(ctx, identityFilter, options.FindOne()).Decode(&user)
+	if err == nil {
+		startSession(c, user)
+		return
+	} else if err != mongo.ErrNoDocuments {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// Link the identity to whoever is logged in, to an existing account with the same
+	// verified email when the provider allows it, or to a brand new account. Emails of
+	// accounts registered with a password were never checked, so anyone could have claimed
+	// one before its owner first signs in through the provider: those accounts are only
+	// linked from a session
+	linkTo := primitive.NilObjectID
+	if cookie, _ := c.Request.Cookie("accessToken"); cookie != nil && cookie.Value != "" {
+		if accessClaims, err := auth.ParseToken(cookie.Value, auth.TokenTypeAccess); err == nil {
+			linkTo, _ = primitive.ObjectIDFromHex(accessClaims.Issuer)
+		}
+	}
+
+	email := idClaims.VerifiedEmail()
+	if linkTo.IsZero() && email != "" {
+		var existing models.User
+		err = UsersColl.FindOne(ctx, bson.M{"email": email}, options.FindOne()).Decode(&existing)
+		if err == nil {
+			if !provider.LinkByEmail || !existing.EmailVerified {
+				responses.Send(c, http.StatusConflict, "Account exists with given email", gin.H{
+					"reason": "log in with your password first to link this identity",
+				})
+				return
+			}
+			linkTo = existing.Id
+		} else if err != mongo.ErrNoDocuments {
+			responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+	}
+
+	if !linkTo.IsZero() {
+		log.Printf("Linking %s identity %s to user %s\n", provider.Name, identity.Subject, linkTo.Hex())
+		err = UsersColl.FindOneAndUpdate(ctx, bson.M{"_id": linkTo},
+			bson.M{"$addToSet": bson.M{"identities": identity}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't link identity", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		// The provider vouches for the email the account already has
+		if email != "" && user.Email == email && !user.EmailVerified {
+			_, err = UsersColl.UpdateOne(ctx, bson.M{"_id": user.Id, "email": email},
+				bson.M{"$set": bson.M{"emailVerified": true}})
+			if err != nil {
+				log.Printf("Couldn't mark email of user %s verified: %s\n", user.Id.Hex(), err.Error())
+			}
+		}
+		startSession(c, user)
+		return
+	}
+
+	// Auto-provision an account on first login. It has no password, so it can only
+	// be signed into through the provider. Accounts are found by their email, so one
+	// is needed
+	if email == "" {
+		responses.Send(c, http.StatusForbidden, "Identity provider didn't share a verified email", gin.H{
+			"reason": "log in first to link this identity to your account",
+		})
+		return
+	}
+	user = models.User{
+		Email:         email,
+		EmailVerified: true,
+		Identities:    []models.Identity{identity},
+	}
+	log.Printf("Provisioning new user for %s identity %s\n", provider.Name, identity.Subject)
+
+	result, err := UsersColl.InsertOne(ctx, user)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't create account", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	user.Id = result.InsertedID.(primitive.ObjectID)
+
+	startSession(c, user)
+}
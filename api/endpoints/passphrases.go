@@ -0,0 +1,138 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	pollGrantHeader                 = "X-Poll-Grant"
+	pollGrantLifetime time.Duration = 2 * time.Hour
+
+	// The minimum is in characters, the maximum in bytes since bcrypt ignores anything past 72
+	minPassphraseLength int = 4
+	maxPassphraseBytes  int = 72
+
+	ReasonPassphraseRequired = "passphrase_required"
+)
+
+// hashPassphrase hashes a new poll's passphrase like an account password
+func hashPassphrase(passphrase string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passphrase), saltRounds)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// pollGrant is the access grant sent with the request, in the body, the X-Poll-Grant
+// header or the `grant` query parameter
+func pollGrant(c *gin.Context, bodyGrant string) string {
+	if len(bodyGrant) > 0 {
+		return bodyGrant
+	}
+	if grant := c.GetHeader(pollGrantHeader); len(grant) > 0 {
+		return grant
+	}
+	return c.Query("grant")
+}
+
+// hasPollAccess reports whether the request may see a protected poll: with a valid access
+// grant for it, or by being its creator. Unprotected polls are open to everyone
+func hasPollAccess(c *gin.Context, poll models.Poll, grant string) bool {
+	if !poll.Protected {
+		return true
+	}
+
+	if accessClaims, exists := c.Get("accessClaims"); exists && !poll.Creator.IsZero() {
+		if userId, err := primitive.ObjectIDFromHex(accessClaims.(*auth.Claims).Issuer); err == nil && userId == poll.Creator {
+			return true
+		}
+	}
+
+	if len(grant) == 0 {
+		return false
+	}
+	claims, err := auth.ParseToken(grant, auth.TokenTypePollGrant)
+	return err == nil && claims.Subject == poll.PollId
+}
+
+// pollStub is all that is shown of a protected poll before its passphrase is given
+func pollStub(poll models.Poll) gin.H {
+	return gin.H{
+		"PollId":    poll.PollId,
+		"Protected": true,
+	}
+}
+
+// UnlockPoll checks a protected poll's passphrase, and hands out a short-lived grant to view,
+// vote on and see the results of the poll
+func UnlockPoll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.UnlockPoll
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !poll.Protected {
+		responses.Send(c, http.StatusBadRequest, "Poll isn't protected by a passphrase", gin.H{})
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(poll.PassphraseHash), []byte(req.Passphrase))
+	if err != nil {
+		log.Printf("Wrong passphrase for poll [%s]\n", poll.PollId)
+		responses.Send(c, http.StatusUnauthorized, "Wrong passphrase", gin.H{})
+		return
+	}
+
+	grant, err := auth.GeneratePollGrant(poll.PollId, pollGrantLifetime)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't create access grant", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Poll unlocked", gin.H{
+		"grant":     grant,
+		"expiresAt": time.Now().Add(pollGrantLifetime),
+	})
+}
+
+// checkPollAccess sends a stub of a protected poll and returns false, unless the request
+// may see it
+func checkPollAccess(c *gin.Context, poll models.Poll, grant string) bool {
+	if hasPollAccess(c, poll, pollGrant(c, grant)) {
+		return true
+	}
+	responses.Send(c, http.StatusOK, "Poll is protected by a passphrase", gin.H{
+		"poll":               pollStub(poll),
+		"passphraseRequired": true,
+	})
+	return false
+}
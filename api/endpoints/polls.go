@@ -5,8 +5,13 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
+	"rapidvote/api/auth"
+	"rapidvote/api/ballotlog"
+	"rapidvote/api/challenge"
 	"rapidvote/api/database"
 	"rapidvote/api/models"
 	"rapidvote/api/requests"
@@ -24,9 +29,34 @@ const pollIdLength uint = 8
 
 var (
 	PollsColl *mongo.Collection = database.Mongo.Database("test").Collection("polls")
-	VotesColl *mongo.Collection = database.Mongo.Database("test").Collection("votes")
 )
 
+// closePoll stops a poll from accepting votes, publishes the Merkle root of its ballot log and
+// certifies its results. Every way of closing a poll goes through here. It reports whether the
+// poll was open before
+func closePoll(ctx context.Context, pollId string) (bool, error) {
+	filter := bson.M{"pollId": pollId, "status": true}
+	result, err := PollsColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": false, "closedAt": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount != 1 {
+		return false, nil
+	}
+
+	// The poll is closed either way, and the log gets sealed and the results certified by
+	// the next one to fetch them. Encrypted polls get their tally frozen instead, and are
+	// certified once the trustees have decrypted it
+	if _, err = appendBallotLog(ctx, pollId, true); err != nil {
+		log.Printf("Couldn't seal ballot log of poll [%s]: %s\n", pollId, err.Error())
+	} else if _, err = freezeTally(ctx, pollId); err != nil {
+		log.Printf("Couldn't freeze encrypted tally of poll [%s]: %s\n", pollId, err.Error())
+	} else if _, err = issueCertificate(ctx, pollId); err != nil && err != errNotDecrypted {
+		log.Printf("Couldn't issue results certificate of poll [%s]: %s\n", pollId, err.Error())
+	}
+	return true, nil
+}
+
 /* CheckExpire (bool, error)
 * Returns -1 upon error, 0 when poll was not expired, and 1 when poll was expired
 * Calls MongoDB to retrieve the expiration date of it and checks whether it has passed.
@@ -46,11 +76,7 @@ func CheckExpire(c *gin.Context, pollId string) (bool, error) {
 	}
 
 	if poll.Status && (poll.Expiration.Before(time.Now())) {
-		filter := bson.M{"pollId": poll.PollId}
-		close := bson.D{
-			{"$set", bson.D{{"status", false}}},
-		}
-		_, err := PollsColl.UpdateOne(ctx, filter, close)
+		_, err := closePoll(ctx, poll.PollId)
 		if err != nil {
 			responses.Send(c, http.StatusInternalServerError, "Couldn't expire poll", gin.H{
 				"reason": err.Error(),
@@ -63,6 +89,39 @@ func CheckExpire(c *gin.Context, pollId string) (bool, error) {
 	return false, nil
 }
 
+// requestUserId returns the ID of the user authenticated by middleware.OptionalAuth, so that
+// callers using a session or an API token always act as themselves. Anonymous requests fall
+// back to the user ID sent in the request body
+func requestUserId(c *gin.Context, bodyUserId string) string {
+	accessClaims, exists := c.Get("accessClaims")
+	if !exists {
+		return bodyUserId
+	}
+	return accessClaims.(*auth.Claims).Issuer
+}
+
+// callerVoter returns the voter authenticated with a session or API token, and their account.
+// A userId sent in the request proves nothing, so voting never goes by it: anyone could vote
+// once for every user ID they know, and use up those users' votes.
+// If ok is false, an error response has already been sent
+func callerVoter(c *gin.Context, ctx context.Context) (primitive.ObjectID, *models.User, bool) {
+	userId, _ := callerUserId(c)
+	if userId.IsZero() {
+		return userId, nil, true
+	}
+
+	user := &models.User{}
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(user)
+	if err != nil {
+		log.Printf("User %s does not exist\n", userId.Hex())
+		responses.Send(c, http.StatusBadRequest, "User does not exist", gin.H{
+			"reason": err.Error(),
+		})
+		return userId, nil, false
+	}
+	return userId, user, true
+}
+
 func CreatePoll(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -74,7 +133,11 @@ func CreatePoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Got CreatePoll request: %+v\n", req)
+	req.Creator = requestUserId(c, req.Creator)
+	// The passphrase and allowed voters stay out of the log
+	log.Printf("Got CreatePoll request for %q (authRequired: %v, inviteOnly: %v, passphrase: %v, dedupe: %s, challenge: %s, results: %s, ballots: %s)\n",
+		req.Name, req.AuthRequired, req.InviteOnly, len(req.Passphrase) > 0, req.DedupePolicy, req.Challenge,
+		req.ResultsVisibility, req.BallotSecrecy)
 
 	// Get the user id from the request
 	creator := primitive.NilObjectID
@@ -89,14 +152,127 @@ func CreatePoll(c *gin.Context) {
 		}
 	}
 
+	if len(req.DedupePolicy) == 0 {
+		req.DedupePolicy = models.DedupeIP
+	}
+	if !models.IsValidDedupePolicy(req.DedupePolicy) {
+		responses.Send(c, http.StatusBadRequest, "Unknown dedupe policy", gin.H{
+			"dedupePolicy": req.DedupePolicy,
+		})
+		return
+	}
+
+	if len(req.ResultsVisibility) == 0 {
+		req.ResultsVisibility = models.ResultsAlways
+	}
+	if !models.IsValidResultsVisibility(req.ResultsVisibility) {
+		responses.Send(c, http.StatusBadRequest, "Unknown results visibility", gin.H{
+			"resultsVisibility": req.ResultsVisibility,
+		})
+		return
+	}
+
+	if len(req.BallotSecrecy) == 0 {
+		req.BallotSecrecy = models.BallotSecret
+	}
+	if !models.IsValidBallotSecrecy(req.BallotSecrecy) {
+		responses.Send(c, http.StatusBadRequest, "Unknown ballot secrecy", gin.H{
+			"ballotSecrecy": req.BallotSecrecy,
+		})
+		return
+	}
+	// Invitations are deliberately never linked to votes, so there'd be no one to list
+	if req.BallotSecrecy == models.BallotPublic && req.InviteOnly {
+		responses.Send(c, http.StatusBadRequest, "Invitation-only polls can't have public ballots", gin.H{})
+		return
+	}
+	if req.Election != nil {
+		if err := req.Election.Validate(); err != nil {
+			responses.Send(c, http.StatusBadRequest, "Invalid election key", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		// Nobody can tell who voted for what on an encrypted poll, not even the server
+		if req.BallotSecrecy == models.BallotPublic {
+			responses.Send(c, http.StatusBadRequest, "Polls with encrypted ballots can't have public ballots", gin.H{})
+			return
+		}
+	}
+	var budget *models.Privacy
+	if req.Privacy != nil {
+		var err error
+		if budget, err = newPrivacy(*req.Privacy); err != nil {
+			responses.Send(c, http.StatusBadRequest, "Invalid differential privacy budget", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		// Both would publish every vote, and with them the exact counts
+		if req.BallotSecrecy == models.BallotPublic || req.Election != nil {
+			responses.Send(c, http.StatusBadRequest, "Polls with differential privacy can't have public or encrypted ballots", gin.H{})
+			return
+		}
+	}
+	// Credentials let voters who logged in to get one vote without being known, so they
+	// need a poll that requires logging in, and one where voters aren't shown anyway
+	if req.AnonymousCredentials && (!req.AuthRequired || req.InviteOnly || req.BallotSecrecy == models.BallotPublic) {
+		responses.Send(c, http.StatusBadRequest, "Anonymous credentials need a poll that requires logging in, without invitations or public ballots", gin.H{})
+		return
+	}
+
+	var passphraseHash string
+	if len(req.Passphrase) > 0 {
+		if utf8.RuneCountInString(req.Passphrase) < minPassphraseLength {
+			responses.SendFieldErrors(c, "Invalid passphrase", []responses.FieldError{{
+				Field:   "passphrase",
+				Message: "Passphrase must be at least " + strconv.Itoa(minPassphraseLength) + " characters long",
+			}})
+			return
+		}
+		if len(req.Passphrase) > maxPassphraseBytes {
+			responses.SendFieldErrors(c, "Invalid passphrase", []responses.FieldError{{
+				Field:   "passphrase",
+				Message: "Passphrase must be at most " + strconv.Itoa(maxPassphraseBytes) + " bytes long",
+			}})
+			return
+		}
+		var err error
+		passphraseHash, err = hashPassphrase(req.Passphrase)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't hash passphrase", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+	}
+
+	if len(req.Challenge) > 0 && !challenge.IsValidKind(req.Challenge) {
+		responses.Send(c, http.StatusBadRequest, "Unknown or unavailable challenge", gin.H{
+			"challenge": req.Challenge,
+		})
+		return
+	}
+
 	poll := models.Poll{
-		Name:         req.Name,
-		Description:  req.Description,
-		Options:      req.Options,
-		Expiration:   req.Expiration,
-		Status:       req.Status,
-		AuthRequired: req.AuthRequired,
-		Creator:      creator,
+		Name:              req.Name,
+		Description:       req.Description,
+		Options:           req.Options,
+		Expiration:        req.Expiration,
+		Status:            req.Status,
+		AuthRequired:      req.AuthRequired,
+		Eligibility:       newEligibility(req.Eligibility),
+		Public:            req.Public,
+		InviteOnly:        req.InviteOnly,
+		Protected:         len(passphraseHash) > 0,
+		PassphraseHash:    passphraseHash,
+		DedupePolicy:      req.DedupePolicy,
+		Challenge:         req.Challenge,
+		ResultsVisibility: req.ResultsVisibility,
+		BallotSecrecy:     req.BallotSecrecy,
+		Election:          req.Election,
+		Privacy:           budget,
+		Creator:           creator,
 	}
 
 	// Generate a new, unique poll ID
@@ -117,6 +293,17 @@ func CreatePoll(c *gin.Context) {
 			createdNewPollId = true
 		}
 	}
+
+	if req.AnonymousCredentials {
+		key, err := newCredentialKey(ctx, poll.PollId)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't create credential key", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		poll.Credentials = &key
+	}
 	log.Printf("Creating new poll: %+v\n", poll)
 
 	// Insert the poll into MongoDB
@@ -149,8 +336,9 @@ func ViewPoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Got ViewPoll request: %+v\n", req)
-	log.Printf("Client IP: %s\n", c.ClientIP())
+	// The request can hold an invitation token or access grant, which stay out of the log
+	log.Printf("Got ViewPoll request for poll [%s]\n", pollId)
+	log.Printf("Client IP: %s\n", clientIP(c))
 
 	// Check if the vote expired
 	expired, err := CheckExpire(c, pollId)
@@ -173,62 +361,96 @@ func ViewPoll(c *gin.Context) {
 		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
 		return
 	}
+	if !checkPollAccess(c, poll, req.Grant) {
+		return
+	}
+
+	// Anonymous visitors get a voter token now, so they can present it when they vote
+	deviceId := ensureVoterDevice(c)
 
 	// Check to see who the user is, and if they can vote or not
-	var userId primitive.ObjectID
-	if len(req.UserId) == 0 {
-		userId = primitive.NilObjectID
-		userAddr := c.ClientIP()
-		log.Printf("Anonymous User, using IP: %s\n", userAddr)
-		filter["voterAddr"] = userAddr
+	userAddr := clientAddr(c)
+	userId, user, ok := callerVoter(c, ctx)
+	if !ok {
+		return
+	}
+	if user == nil {
+		log.Printf("Anonymous User, using device %s and IP: %s\n", deviceId, userAddr)
 	} else {
-		userId, err = primitive.ObjectIDFromHex(req.UserId)
-		if err != nil {
-			responses.Send(c, http.StatusInternalServerError, "Malformed user ID", gin.H{
-				"reason": err.Error(),
-			})
-			return
-		}
+		log.Printf("Registered User, using ID: %s\n", userId)
+	}
 
-		// Check if the user is actually a valid user
-		err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Err()
+	reason := ineligibility(c, poll, user)
+	if poll.InviteOnly {
+		// Invitations replace accounts and devices, whoever holds one can vote once
+		reason, err = invitationIneligibility(ctx, poll, req.Invitation)
 		if err != nil {
-			log.Printf("User %s does not exist\n", userId.Hex())
-			responses.Send(c, http.StatusBadRequest, "User does not exist", gin.H{
+			responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
 				"reason": err.Error(),
 			})
 			return
 		}
-
-		log.Printf("Registered User, using ID: %s\n", userId)
-		filter["voterId"] = userId
 	}
+	// Under IP dedupe the vote found can be someone else's behind the same address, so only
+	// whether there is one is sent back
+	if len(reason) == 0 && !poll.InviteOnly {
+		if voter := voterFilter(poll, userId, deviceId, userAddr); voter != nil {
+			err = ParticipationsColl.FindOne(ctx, voter, options.FindOne()).Err()
+			if err == nil {
+				reason = ReasonAlreadyVoted
+			} else if err != mongo.ErrNoDocuments {
+				responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+					"reason": err.Error(),
+				})
+				return
+			}
+		}
+	}
+	canVote := len(reason) == 0
+	log.Printf("canVote: %v %s\n", canVote, reason)
 
-	canVote := false
-	var pastVote models.Vote
-	err = VotesColl.FindOne(ctx, filter, options.FindOne()).Decode(&pastVote)
-	if err != nil {
-		// If a Vote document wasn't found for this user, they can vote on the poll
-		if err == mongo.ErrNoDocuments {
-			canVote = true
-		} else {
-			responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+	// Voters without a session get the challenge they have to solve along with the poll
+	var voteChallenge *challenge.Challenge
+	if canVote && poll.Status && len(poll.Challenge) > 0 && userId.IsZero() && !poll.InviteOnly {
+		voteChallenge, err = issueVoteChallenge(ctx, poll)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't issue challenge", gin.H{
 				"reason": err.Error(),
 			})
 			return
 		}
-	} else {
-		log.Printf("Vote found for user: %+v\n", pastVote)
 	}
-	log.Printf("canVote: %v\n", canVote)
 
 	responses.Send(c, http.StatusOK, "Found poll", gin.H{
-		"poll":     poll,
-		"canVote":  canVote,
-		"pastVote": pastVote,
+		"poll":          poll,
+		"creator":       creatorProfile(ctx, poll.Creator),
+		"canVote":       canVote,
+		"canVoteReason": reason,
+		"canSeeResults": canSeeResults(c, poll, reason == ReasonAlreadyVoted),
+		"voted":         reason == ReasonAlreadyVoted,
+		"challenge":     voteChallenge,
 	})
 }
 
+// checkBallot makes sure the poll still takes votes and the vote is for one of its options,
+// before anything is cast on any of the ways to vote. Once a poll is closed its ballot log
+// is sealed, and whatever is in it is published and tallied.
+// If it returns false, an error response has already been sent
+func checkBallot(c *gin.Context, poll models.Poll, req requests.VotePoll) bool {
+	if isPollClosed(poll) {
+		responses.Send(c, http.StatusConflict, "Poll is closed", gin.H{})
+		return false
+	}
+	// Encrypted ballots prove they're for one of the options on their own
+	if poll.Election == nil && int(req.Choice) >= len(poll.Options) {
+		responses.Send(c, http.StatusBadRequest, "Unknown option", gin.H{
+			"choice": req.Choice,
+		})
+		return false
+	}
+	return true
+}
+
 func VotePoll(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -240,73 +462,136 @@ func VotePoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Got VotePoll request: %+v\n", req)
+	// Nothing that could tie a voter to their choice, or spend their invitation or
+	// credential, is logged
+	log.Printf("Got VotePoll request for poll [%s]\n", req.PollId)
 
-	filter := bson.M{"pollId": req.PollId}
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !hasPollAccess(c, poll, pollGrant(c, req.Grant)) {
+		responses.Send(c, http.StatusForbidden, "This poll is protected by a passphrase", gin.H{
+			"reason": ReasonPassphraseRequired,
+		})
+		return
+	}
+	if !checkBallot(c, poll, req) {
+		return
+	}
+	if poll.InviteOnly {
+		voteWithInvitation(c, ctx, poll, req)
+		return
+	}
+	if poll.Credentials != nil {
+		voteWithCredential(c, ctx, poll, req)
+		return
+	}
+	policy := dedupePolicy(poll)
 
-	var err error
-	var userId primitive.ObjectID
-	userAddr := c.ClientIP()
+	userAddr := clientAddr(c)
+	deviceId, hasDevice := voterDevice(c)
 
-	if len(req.UserId) == 0 {
-		userId = primitive.NilObjectID
-		log.Printf("Anonymous User, using IP: %s\n", userAddr)
-		filter["voterAddr"] = userAddr
-	} else {
-		userId, err = primitive.ObjectIDFromHex(req.UserId)
-		if err != nil {
-			responses.Send(c, http.StatusInternalServerError, "Malformed user ID", gin.H{
-				"reason": err.Error(),
-			})
+	userId, user, ok := callerVoter(c, ctx)
+	if !ok {
+		return
+	}
+	// Without a token a device could vote again just by dropping its cookies
+	if user == nil && policy == models.DedupeDevice && !hasDevice {
+		responses.Send(c, http.StatusBadRequest, "Missing voter token, view the poll before voting", gin.H{})
+		return
+	}
+
+	if reason := ineligibility(c, poll, user); len(reason) > 0 {
+		status := http.StatusForbidden
+		if reason == ReasonLoginRequired {
+			status = http.StatusUnauthorized
+		}
+		responses.Send(c, status, "You aren't eligible to vote on this poll", gin.H{
+			"reason": reason,
+		})
+		return
+	}
+
+	// Only voters with a session skip the challenge
+	var solved *challenge.Solved
+	if userId.IsZero() && len(poll.Challenge) > 0 {
+		if solved, ok = checkVoteChallenge(c, ctx, poll, req.Challenge, req.Solution); !ok {
 			return
 		}
-		log.Printf("Registered User, using ID: %s\n", userId.Hex())
-		filter["voterId"] = userId
+	}
+	if !checkEncryptedBallot(c, ctx, poll, req.EncryptedBallot) {
+		return
+	}
 
-		// Check if the user is actually a valid user
-		err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Err()
-		if err != nil {
-			log.Printf("User %s does not exist\n", userId.Hex())
-			responses.Send(c, http.StatusBadRequest, "User does not exist", gin.H{
+	vote := models.Participation{
+		PollId:       req.PollId,
+		VoterId:      userId,
+		VoterAddr:    userAddr,
+		VoterIPChain: clientIPChain(c),
+		VoterDevice:  deviceId,
+		VoterSubnet:  voterSubnet(clientIP(c)),
+		UserAgent:    voterUserAgent(c),
+		DedupeKeys:   voterDedupeKeys(poll, userId, deviceId, userAddr),
+		CastAt:       time.Now(),
+	}
+
+	// Check if this user has already voted. Votes from before dedupe keys are only found
+	// this way, and castVote catches those cast at the same time
+	if filter := voterFilter(poll, userId, deviceId, userAddr); filter != nil {
+		err = ParticipationsColl.FindOne(ctx, filter, options.FindOne()).Err()
+		if err == nil {
+			// If the user already voted
+			log.Printf("Rejected repeat vote on poll [%s]\n", req.PollId)
+			responses.Send(c, http.StatusBadRequest, "Vote already found for user", gin.H{})
+			return
+		} else if err != mongo.ErrNoDocuments {
+			responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
 				"reason": err.Error(),
 			})
 			return
 		}
 	}
 
-	vote := models.Vote{
-		PollId:    req.PollId,
-		Choice:    req.Choice,
-		VoterId:   userId,
-		VoterAddr: userAddr,
+	// Suspicious votes are still cast, but don't count until they are reviewed
+	flags, err := detectAnomalies(ctx, vote)
+	if err != nil {
+		log.Printf("Couldn't check vote on poll [%s] for anomalies: %s\n", vote.PollId, err.Error())
+	} else if len(flags) > 0 {
+		log.Printf("Quarantining vote on poll [%s]: %v\n", vote.PollId, flags)
+		vote.Status = models.VoteQuarantined
+		vote.Flags = flags
 	}
 
-	// Check if this user has already voted
-	var pastVote models.Vote
-	err = VotesColl.FindOne(ctx, filter, options.FindOne()).Decode(&pastVote)
-	if err == nil {
-		// If the user already voted
-		log.Printf("User %s already voted: %+v", pastVote.VoterId.Hex(), pastVote)
-		responses.Send(c, http.StatusBadRequest, "Vote already found for user", gin.H{
-			"userId": pastVote.VoterId,
-		})
+	ballot, err := castVote(ctx, poll, vote, req.Choice, req.EncryptedBallot, solved)
+	if err == errChallengeSpent {
+		sendChallengeSpent(c)
 		return
-	} else if err != mongo.ErrNoDocuments {
-		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
-			"reason": err.Error(),
-		})
+	} else if err == errAlreadyVoted {
+		log.Printf("Rejected repeat vote on poll [%s]\n", req.PollId)
+		responses.Send(c, http.StatusBadRequest, "Vote already found for user", gin.H{})
 		return
-	}
-
-	// Insert the vote into the database
-	_, err = VotesColl.InsertOne(ctx, vote)
-	if err != nil {
+	} else if err == errBallotCopied {
+		sendBallotCopied(c)
+		return
+	} else if err != nil {
 		responses.Send(c, http.StatusInternalServerError, "Couldn't cast vote", gin.H{
 			"reason": err.Error(),
 		})
 		return
 	}
-	responses.Send(c, http.StatusOK, "Vote was cast", gin.H{})
+
+	// Quarantined votes get their ballot once they're accepted, and no receipt
+	var receipt *ballotlog.Receipt
+	if ballot != nil {
+		r := ballotReceipt(*ballot)
+		receipt = &r
+	}
+	responses.Send(c, http.StatusOK, "Vote was cast", gin.H{
+		"receipt": receipt,
+	})
 }
 
 func ClosePoll(c *gin.Context) {
@@ -320,18 +605,14 @@ func ClosePoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Got ClosePoll request: %+v\n", req)
+	log.Printf("Got ClosePoll request for poll [%s]\n", req.PollId)
 
-	poll := models.Poll{
-		PollId: req.PollId,
-		Status: false,
+	poll, _, ok := findManagedPoll(c, ctx, req.PollId, "close it")
+	if !ok {
+		return
 	}
 
-	filter := bson.M{"pollId": poll.PollId}
-	closeMe := bson.D{
-		{"$set", bson.D{{"status", false}}},
-	}
-	_, err := PollsColl.UpdateOne(ctx, filter, closeMe)
+	_, err := closePoll(ctx, poll.PollId)
 	if err != nil {
 		responses.Send(c, http.StatusInternalServerError, "Couldn't close poll", gin.H{
 			"reason": err.Error(),
@@ -343,6 +624,32 @@ func ClosePoll(c *gin.Context) {
 	responses.Send(c, http.StatusOK, "Vote was closed", gin.H{})
 }
 
+// countVotes counts the ballots for each option of the poll, keyed by option index.
+// Quarantined and rejected votes have no ballot, so they aren't counted. Encrypted polls
+// have no counts until the trustees decrypt their tally, see errNotDecrypted
+func countVotes(ctx context.Context, poll models.Poll) (map[int]int64, error) {
+	count := make(map[int]int64)
+	if poll.Election != nil {
+		tally, err := decryptedTally(ctx, poll.PollId)
+		if err != nil {
+			return nil, err
+		}
+		for optionIndex := range poll.Options {
+			count[optionIndex] = tally.Counts[optionIndex]
+		}
+		return count, nil
+	}
+	for optionIndex := range poll.Options {
+		filter := bson.M{"pollId": poll.PollId, "choice": optionIndex, "held": countedBallot}
+		optionVoteCount, err := BallotsColl.CountDocuments(ctx, filter, options.Count())
+		if err != nil {
+			return nil, err
+		}
+		count[optionIndex] = optionVoteCount
+	}
+	return count, nil
+}
+
 func GetPollResult(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -360,21 +667,54 @@ func GetPollResult(c *gin.Context) {
 		return
 	}
 
-	// Count the votes for each option, and store them in a map
-	count := make(map[int]int64)
-	for optionIndex := range poll.Options {
-		filter := bson.M{"pollId": pollId, "choice": optionIndex}
-		optionVoteCount, err := VotesColl.CountDocuments(ctx, filter, options.Count())
-		if err != nil {
-			responses.Send(c, http.StatusInternalServerError, "Couldn't count vote for poll result", gin.H{
-				"reason": err.Error(),
-			})
-		}
-		count[optionIndex] = optionVoteCount
+	if !checkPollAccess(c, poll, "") {
+		return
 	}
 
-	responses.Send(c, http.StatusOK, "Successfully got poll results", gin.H{
-		"poll":  poll,
-		"count": count,
-	})
+	if !checkResultsVisible(c, ctx, poll, c.Query("invitation")) {
+		return
+	}
+	if !canSeeExactCounts(c, poll) {
+		sendNoisyResults(c, ctx, poll)
+		return
+	}
+
+	count, err := countVotes(ctx, poll)
+	if err == errNotDecrypted {
+		responses.Send(c, http.StatusOK, "Results are encrypted until the trustees decrypt them", gin.H{
+			"poll":               poll,
+			"creator":            creatorProfile(ctx, poll.Creator),
+			"count":              nil,
+			"awaitingDecryption": true,
+		})
+		return
+	} else if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count vote for poll result", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	quarantined, err := ParticipationsColl.CountDocuments(ctx, bson.M{"pollId": poll.PollId, "status": models.VoteQuarantined}, options.Count())
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't count vote for poll result", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	result := gin.H{
+		"poll":        poll,
+		"creator":     creatorProfile(ctx, poll.Creator),
+		"count":       count,
+		"quarantined": quarantined,
+	}
+	if poll.Privacy != nil {
+		result["differentialPrivacy"] = gin.H{
+			"mechanism":     poll.Privacy.Mechanism,
+			"epsilonBudget": poll.Privacy.Epsilon,
+			"exact":         true,
+		}
+	}
+	responses.Send(c, http.StatusOK, "Successfully got poll results", result)
 }
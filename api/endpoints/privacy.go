@@ -0,0 +1,203 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/privacy"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxNoisyReleases int = 100
+
+	ReasonDifferentialPrivacy = "differential_privacy"
+)
+
+var (
+	NoisyResultsColl *mongo.Collection = database.Mongo.Database("test").Collection("noisy_results")
+
+	// How long noisy results are served before the next release, while the poll is open
+	noisyReleaseInterval = time.Duration(util.EnvInt("DP_RELEASE_INTERVAL_MINUTES", 60)) * time.Minute
+)
+
+// newPrivacy checks the differential privacy budget sent with CreatePoll, and fills in its
+// defaults
+func newPrivacy(req requests.Privacy) (*models.Privacy, error) {
+	budget := models.Privacy{
+		Mechanism: req.Mechanism,
+		Epsilon:   req.Epsilon,
+		Delta:     req.Delta,
+		Releases:  req.Releases,
+	}
+	if budget.Releases == 0 {
+		budget.Releases = 1
+	}
+	if budget.Releases < 1 || budget.Releases > maxNoisyReleases {
+		return nil, errors.New("releases must be between 1 and 100")
+	}
+	if budget.Mechanism == privacy.Gaussian && budget.Delta == 0 {
+		budget.Delta = privacy.DefaultDelta
+	} else if budget.Mechanism == privacy.Laplace {
+		budget.Delta = 0
+	}
+	epsilon, delta := releaseBudget(budget)
+	if err := privacy.Validate(budget.Mechanism, epsilon, delta); err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+// releaseBudget is what each release spends
+func releaseBudget(budget models.Privacy) (float64, float64) {
+	return budget.Epsilon / float64(budget.Releases), budget.Delta / float64(budget.Releases)
+}
+
+// canSeeExactCounts reports whether the caller may see the poll's exact counts, which on
+// polls with differential privacy is only their creator once the poll is closed
+func canSeeExactCounts(c *gin.Context, poll models.Poll) bool {
+	if poll.Privacy == nil {
+		return true
+	}
+	userId, _ := callerUserId(c)
+	return !poll.Creator.IsZero() && userId == poll.Creator && isPollClosed(poll)
+}
+
+// checkExactCounts keeps whatever gives away exact counts, like the ballot log and results
+// certificate, for those who may see them.
+// If it returns false, an error response has already been sent
+func checkExactCounts(c *gin.Context, poll models.Poll) bool {
+	if !canSeeExactCounts(c, poll) {
+		responses.Send(c, http.StatusForbidden, "Only the creator sees exact results of this poll, once it's closed", gin.H{
+			"reason": ReasonDifferentialPrivacy,
+		})
+		return false
+	}
+	return true
+}
+
+// releaseNoisyResults returns the latest release of the poll's noisy results, drawing a new
+// one when it's due and the budget allows. The last release is always kept for when the
+// poll closes, so polls with a single release show nothing until then
+func releaseNoisyResults(ctx context.Context, poll models.Poll) (*models.NoisyRelease, models.NoisyResults, error) {
+	results := models.NoisyResults{PollId: poll.PollId, Releases: []models.NoisyRelease{}}
+	err := NoisyResultsColl.FindOne(ctx, bson.M{"_id": poll.PollId}, options.FindOne()).Decode(&results)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, results, err
+	}
+
+	var latest *models.NoisyRelease
+	if results.Count > 0 {
+		latest = &results.Releases[results.Count-1]
+	}
+	closed := isPollClosed(poll)
+	var due bool
+	if closed {
+		due = (latest == nil || !latest.Final) && results.Count < poll.Privacy.Releases
+	} else {
+		due = results.Count < poll.Privacy.Releases-1 && (latest == nil || time.Since(latest.ReleasedAt) >= noisyReleaseInterval)
+	}
+	if !due {
+		return latest, results, nil
+	}
+
+	count, err := countVotes(ctx, poll)
+	if err != nil {
+		return nil, results, err
+	}
+	exact := make([]int64, len(poll.Options))
+	for optionIndex := range poll.Options {
+		exact[optionIndex] = count[optionIndex]
+	}
+	epsilon, delta := releaseBudget(*poll.Privacy)
+	noisy, err := privacy.Noisy(poll.Privacy.Mechanism, epsilon, delta, exact)
+	if err != nil {
+		return nil, results, err
+	}
+
+	previousCount := results.Count
+	results.Releases = append(results.Releases, models.NoisyRelease{
+		Counts:     noisy,
+		Epsilon:    epsilon,
+		Delta:      delta,
+		Final:      closed,
+		ReleasedAt: time.Now(),
+	})
+	results.Count++
+
+	// Matching the count we started from means only one of two concurrent releases is kept,
+	// and both callers serve that one
+	_, err = NoisyResultsColl.ReplaceOne(ctx, bson.M{"_id": poll.PollId, "count": previousCount}, results,
+		options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		results = models.NoisyResults{}
+		if err = NoisyResultsColl.FindOne(ctx, bson.M{"_id": poll.PollId}, options.FindOne()).Decode(&results); err != nil {
+			return nil, results, err
+		}
+	} else if err != nil {
+		return nil, results, err
+	}
+	return &results.Releases[results.Count-1], results, nil
+}
+
+// sendNoisyResults responds to GetPollResult with the poll's noisy results
+func sendNoisyResults(c *gin.Context, ctx context.Context, poll models.Poll) {
+	release, results, err := releaseNoisyResults(ctx, poll)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't release poll results", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	spent := 0.0
+	for _, r := range results.Releases {
+		spent += r.Epsilon
+	}
+	dp := gin.H{
+		"mechanism":     poll.Privacy.Mechanism,
+		"epsilonBudget": poll.Privacy.Epsilon,
+		"epsilonSpent":  spent,
+		"releases":      results.Count,
+		"maxReleases":   poll.Privacy.Releases,
+		"exact":         false,
+	}
+	if release == nil {
+		responses.Send(c, http.StatusOK, "Results are released once the poll closes", gin.H{
+			"poll":                poll,
+			"creator":             creatorProfile(ctx, poll.Creator),
+			"count":               nil,
+			"differentialPrivacy": dp,
+		})
+		return
+	}
+
+	dp["epsilon"] = release.Epsilon
+	if release.Delta > 0 {
+		dp["delta"] = release.Delta
+	}
+	dp["noiseScale"] = privacy.Scale(poll.Privacy.Mechanism, release.Epsilon, release.Delta)
+	dp["releasedAt"] = release.ReleasedAt
+	dp["final"] = release.Final
+	count := make(map[int]int64)
+	for optionIndex, optionCount := range release.Counts {
+		count[optionIndex] = optionCount
+	}
+	responses.Send(c, http.StatusOK, "Successfully got poll results, with differential privacy noise", gin.H{
+		"poll":                poll,
+		"creator":             creatorProfile(ctx, poll.Creator),
+		"count":               count,
+		"differentialPrivacy": dp,
+	})
+}
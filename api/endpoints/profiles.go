@@ -0,0 +1,342 @@
+package endpoints
+
+import (
+	"context"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/image/draw"
+)
+
+const (
+	maxDisplayNameLength int = 50
+	maxBioLength         int = 500
+
+	maxAvatarUploadSize int64 = 5 << 20
+	maxAvatarDimension  int   = 4096
+	avatarSize          int   = 256
+)
+
+var (
+	avatarDir = avatarDirectory()
+)
+
+func avatarDirectory() string {
+	if dir := os.Getenv("AVATAR_DIR"); dir != "" {
+		return dir
+	}
+	return "avatars"
+}
+
+func publicProfile(user models.User) models.PublicProfile {
+	profile := models.PublicProfile{
+		UserId:      user.Id,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+	}
+	if len(user.Avatar) > 0 {
+		// The file name changes with every upload, so it doubles as a cache buster
+		profile.AvatarUrl = "/api/profiles/" + user.Id.Hex() + "/avatar?v=" + strings.TrimSuffix(user.Avatar, ".png")
+	}
+	return profile
+}
+
+// creatorProfile looks up the public profile of a poll's creator. Polls without a creator,
+// or whose creator no longer exists, have no profile
+func creatorProfile(ctx context.Context, creator primitive.ObjectID) *models.PublicProfile {
+	if creator.IsZero() {
+		return nil
+	}
+
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": creator}, options.FindOne()).Decode(&user)
+	if err != nil {
+		return nil
+	}
+
+	profile := publicProfile(user)
+	return &profile
+}
+
+func GetProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Account not found", gin.H{})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found profile", gin.H{
+		"email":   user.Email,
+		"profile": publicProfile(user),
+	})
+}
+
+func UpdateProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.UpdateProfile
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Bio = strings.TrimSpace(req.Bio)
+
+	var fieldErrors []responses.FieldError
+	if utf8.RuneCountInString(req.DisplayName) > maxDisplayNameLength {
+		fieldErrors = append(fieldErrors, responses.FieldError{
+			Field:   "displayName",
+			Message: "Display name must be at most 50 characters long",
+		})
+	}
+	if utf8.RuneCountInString(req.Bio) > maxBioLength {
+		fieldErrors = append(fieldErrors, responses.FieldError{
+			Field:   "bio",
+			Message: "Bio must be at most 500 characters long",
+		})
+	}
+	if len(fieldErrors) > 0 {
+		responses.SendFieldErrors(c, "Invalid profile", fieldErrors)
+		return
+	}
+
+	var user models.User
+	err := UsersColl.FindOneAndUpdate(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{
+		"displayName": req.DisplayName,
+		"bio":         req.Bio,
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update profile", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Updated profile", gin.H{
+		"profile": publicProfile(user),
+	})
+}
+
+// UploadAvatar takes a PNG, JPEG or GIF from the `avatar` form field, crops it to a square
+// and stores it resized to avatarSize x avatarSize
+func UploadAvatar(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarUploadSize)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't read avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't read avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	// Check the dimensions before decoding, so a tiny file can't claim a gigantic image
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Avatar must be a PNG, JPEG or GIF image", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		responses.Send(c, http.StatusBadRequest, "Avatar is too large", gin.H{
+			"maxDimension": maxAvatarDimension,
+		})
+		return
+	}
+	if _, err = file.Seek(0, 0); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't read avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Avatar must be a PNG, JPEG or GIF image", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// Crop the largest centered square, then scale it down
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	avatar := image.NewRGBA(image.Rect(0, 0, avatarSize, avatarSize))
+	draw.CatmullRom.Scale(avatar, avatar.Bounds(), src, crop, draw.Over, nil)
+
+	if err = os.MkdirAll(avatarDir, 0755); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't store avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	fileName := userId.Hex() + "-" + util.GenRandomString(8) + ".png"
+	out, err := os.Create(filepath.Join(avatarDir, fileName))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't store avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	err = png.Encode(out, avatar)
+	out.Close()
+	if err != nil {
+		os.Remove(filepath.Join(avatarDir, fileName))
+		responses.Send(c, http.StatusInternalServerError, "Couldn't store avatar", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var previous models.User
+	err = UsersColl.FindOneAndUpdate(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"avatar": fileName}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+	if err != nil {
+		os.Remove(filepath.Join(avatarDir, fileName))
+		responses.Send(c, http.StatusInternalServerError, "Couldn't update profile", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if len(previous.Avatar) > 0 {
+		os.Remove(filepath.Join(avatarDir, previous.Avatar))
+	}
+	log.Printf("Stored new avatar for user %s\n", userId.Hex())
+
+	previous.Avatar = fileName
+	responses.Send(c, http.StatusOK, "Updated avatar", gin.H{
+		"profile": publicProfile(previous),
+	})
+}
+
+// ViewProfile is a user's public profile, along with the polls they chose to make public
+func ViewProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := findProfileUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	// Protected polls stay hidden, their passphrase is what keeps them private
+	filter := bson.M{"creator": user.Id, "public": true, "protected": bson.M{"$ne": true}}
+	cursor, err := PollsColl.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	polls := []models.Poll{}
+	if err = cursor.All(ctx, &polls); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse polls", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found profile", gin.H{
+		"profile": publicProfile(user),
+		"polls":   polls,
+	})
+}
+
+func GetAvatar(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := findProfileUser(c, ctx)
+	if !ok {
+		return
+	}
+	if len(user.Avatar) == 0 {
+		responses.Send(c, http.StatusNotFound, "User has no avatar", gin.H{})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(filepath.Join(avatarDir, user.Avatar))
+}
+
+// findProfileUser finds the user in the `userId` path parameter. Disabled and deactivated
+// users have no public profile. If it returns false, an error response has already been sent
+func findProfileUser(c *gin.Context, ctx context.Context) (models.User, bool) {
+	userId, err := primitive.ObjectIDFromHex(c.Params.ByName("userId"))
+	if err != nil {
+		responses.Send(c, http.StatusBadRequest, "Malformed user ID", gin.H{
+			"reason": err.Error(),
+		})
+		return models.User{}, false
+	}
+
+	var user models.User
+	err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil || user.Disabled || user.DeactivatedAt != nil {
+		responses.Send(c, http.StatusNotFound, "User does not exist", gin.H{})
+		return models.User{}, false
+	}
+	return user, true
+}
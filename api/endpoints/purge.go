@@ -0,0 +1,211 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rapidvote/api/models"
+	"rapidvote/api/util"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// How long a deactivated account can still be reactivated before it is purged
+	deactivationGraceDays int = util.EnvInt("DEACTIVATION_GRACE_DAYS", 30)
+)
+
+// StartPurgeJob purges every deactivated account whose grace period has passed, every
+// expired data export and every expired spent challenge, and keeps doing so every `interval`
+func StartPurgeJob(interval time.Duration) {
+	go func() {
+		for {
+			purgeDeactivatedUsers()
+			purgeExpiredExports()
+			purgeSpentChallenges()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func purgeDeactivatedUsers() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"purgeAt": bson.M{"$lte": time.Now()}}
+	cursor, err := UsersColl.Find(ctx, filter, options.Find())
+	if err != nil {
+		log.Printf("Couldn't find users to purge: %s\n", err.Error())
+		return
+	}
+
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Printf("Couldn't parse users to purge: %s\n", err.Error())
+		return
+	}
+
+	for _, user := range users {
+		if err := purgeUser(ctx, user); err != nil {
+			// The user is kept, so the purge is retried on the next run
+			log.Printf("Couldn't purge user %s: %s\n", user.Id.Hex(), err.Error())
+			continue
+		}
+		log.Printf("Purged deactivated user %s\n", user.Id.Hex())
+	}
+}
+
+// deletePollData deletes the polls and everything kept for them, in one transaction so that
+// failing part of the way through doesn't leave votes behind for polls that are gone. It
+// returns how many polls and ballots were deleted
+func deletePollData(ctx context.Context, pollIds []interface{}) (int64, int64, error) {
+	inPolls := bson.M{"$in": pollIds}
+	var polls, ballots int64
+	err := inTransaction(ctx, func(sc mongo.SessionContext) error {
+		for _, coll := range []*mongo.Collection{
+			PollsColl,
+			ParticipationsColl,
+			BallotsColl,
+			BallotFingerprintsColl,
+			CredentialIssuancesColl,
+			SpentCredentialsColl,
+			SpentChallengesColl,
+			InvitationsColl,
+		} {
+			result, err := coll.DeleteMany(sc, bson.M{"pollId": inPolls})
+			if err != nil {
+				return err
+			}
+			switch coll {
+			case PollsColl:
+				polls = result.DeletedCount
+			case BallotsColl:
+				ballots = result.DeletedCount
+			}
+		}
+		// These are kept under the poll's ID
+		for _, coll := range []*mongo.Collection{
+			BallotLogsColl,
+			CertificatesColl,
+			TalliesColl,
+			CredentialKeysColl,
+			NoisyResultsColl,
+		} {
+			if _, err := coll.DeleteMany(sc, bson.M{"_id": inPolls}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return polls, ballots, err
+}
+
+// purgeUser deletes the account, after handling its polls and votes as the user chose
+func purgeUser(ctx context.Context, user models.User) error {
+	choices := models.DeactivationChoices{Polls: models.PollsAnonymize, KeepVotes: true}
+	if user.Deactivation != nil {
+		choices = *user.Deactivation
+	}
+
+	creatorFilter := bson.M{"creator": user.Id}
+	switch choices.Polls {
+	case models.PollsDelete:
+		pollIds, err := PollsColl.Distinct(ctx, "pollId", creatorFilter)
+		if err != nil {
+			return err
+		}
+		if len(pollIds) > 0 {
+			if _, _, err = deletePollData(ctx, pollIds); err != nil {
+				return err
+			}
+		}
+	case models.PollsTransfer:
+		// Fall back to anonymizing if the recipient went away in the meantime
+		newCreator := primitive.NilObjectID
+		count, err := UsersColl.CountDocuments(ctx, bson.M{"_id": choices.TransferTo, "deactivatedAt": nil})
+		if err != nil {
+			return err
+		}
+		if count == 1 {
+			newCreator = choices.TransferTo
+		}
+		if _, err = PollsColl.UpdateMany(ctx, creatorFilter, bson.M{"$set": bson.M{"creator": newCreator}}); err != nil {
+			return err
+		}
+	default:
+		_, err := PollsColl.UpdateMany(ctx, creatorFilter, bson.M{"$set": bson.M{"creator": primitive.NilObjectID}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Secret ballots don't know who cast them, so only participations and public ballots
+	// can be anonymized or deleted
+	votesFilter := bson.M{"voterId": user.Id}
+	if choices.KeepVotes {
+		_, err := ParticipationsColl.UpdateMany(ctx, votesFilter, anonymizeVoter)
+		if err != nil {
+			return err
+		}
+		if _, err = BallotsColl.UpdateMany(ctx, votesFilter, bson.M{"$unset": bson.M{"voterId": ""}}); err != nil {
+			return err
+		}
+	} else {
+		// Held ballots are only known through the participation that refers to them
+		var held []models.Participation
+		cursor, err := ParticipationsColl.Find(ctx, bson.M{"voterId": user.Id, "heldBallot": bson.M{"$exists": true}}, options.Find())
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(ctx, &held); err != nil {
+			return err
+		}
+		for _, participation := range held {
+			ballot, err := findHeldBallot(ctx, participation.PollId, participation.HeldBallot)
+			if err == mongo.ErrNoDocuments {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if _, err = BallotsColl.DeleteOne(ctx, bson.M{"_id": ballot.BallotId, "held": true}); err != nil {
+				return err
+			}
+		}
+		if _, err := ParticipationsColl.DeleteMany(ctx, votesFilter); err != nil {
+			return err
+		}
+		if _, err := BallotsColl.DeleteMany(ctx, votesFilter); err != nil {
+			return err
+		}
+	}
+
+	// Ballots cast with credentials can't be traced back, only the credentials issued are
+	if _, err := CredentialIssuancesColl.DeleteMany(ctx, bson.M{"userId": user.Id}); err != nil {
+		return err
+	}
+
+	if _, err := TokensColl.DeleteMany(ctx, bson.M{"owner": user.Id}); err != nil {
+		return err
+	}
+	// Archives left on disk are removed by purgeExpiredExports
+	if _, err := ExportsColl.UpdateMany(ctx, bson.M{"owner": user.Id}, bson.M{"$set": bson.M{"expiresAt": time.Now()}}); err != nil {
+		return err
+	}
+	if _, err := LoginAttemptsColl.DeleteOne(ctx, bson.M{"key": accountAttemptsKey(user.Email)}); err != nil {
+		return err
+	}
+
+	if _, err := UsersColl.DeleteOne(ctx, bson.M{"_id": user.Id}); err != nil {
+		return err
+	}
+	if len(user.Avatar) > 0 {
+		os.Remove(filepath.Join(avatarDir, user.Avatar))
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"rapidvote/api/database"
+	"rapidvote/api/elgamal"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ReasonBallotCopied = "ballot_copied"
+
+var (
+	// TalliesColl holds the frozen encrypted tally of every closed encrypted poll
+	TalliesColl *mongo.Collection = database.Mongo.Database("test").Collection("tallies")
+	// BallotFingerprintsColl remembers every encrypted ballot cast, by its fingerprint
+	BallotFingerprintsColl *mongo.Collection = database.Mongo.Database("test").Collection("ballot_fingerprints")
+)
+
+// errNotDecrypted is returned when counting an encrypted poll the trustees haven't
+// decrypted yet
+var errNotDecrypted = errors.New("tally hasn't been decrypted yet")
+
+// errBallotCopied is returned when casting an encrypted ballot that was cast before
+var errBallotCopied = errors.New("encrypted ballot was already cast")
+
+// ballotFingerprintId is what an encrypted ballot is remembered by
+func ballotFingerprintId(pollId string, ballot elgamal.Ballot) string {
+	return pollId + "/" + ballot.Fingerprint()
+}
+
+// recordBallotFingerprint remembers an encrypted ballot as it's cast, in the same transaction.
+// Its proofs are valid for anyone who copies it, so a copy would be counted again, and would
+// repeat someone else's vote without knowing what it is. Fingerprints of rejected ballots are
+// kept, so their copies stay out too
+func recordBallotFingerprint(sc mongo.SessionContext, pollId string, ballot *elgamal.Ballot) error {
+	if ballot == nil {
+		return nil
+	}
+	_, err := BallotFingerprintsColl.InsertOne(sc, bson.M{
+		"_id":    ballotFingerprintId(pollId, *ballot),
+		"pollId": pollId,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return errBallotCopied
+	}
+	return err
+}
+
+// sendBallotCopied is the response to errBallotCopied
+func sendBallotCopied(c *gin.Context) {
+	responses.Send(c, http.StatusConflict, "Encrypted ballot was already cast", gin.H{
+		"reason": ReasonBallotCopied,
+	})
+}
+
+// checkEncryptedBallot makes sure votes on encrypted polls come with a valid encrypted
+// ballot that wasn't cast before, and votes on other polls don't.
+// If it returns false, an error response has already been sent
+func checkEncryptedBallot(c *gin.Context, ctx context.Context, poll models.Poll, ballot *elgamal.Ballot) bool {
+	if poll.Election == nil {
+		if ballot != nil {
+			responses.Send(c, http.StatusBadRequest, "Poll doesn't take encrypted ballots", gin.H{})
+			return false
+		}
+		return true
+	}
+	if ballot == nil {
+		responses.Send(c, http.StatusBadRequest, "Poll only takes encrypted ballots", gin.H{})
+		return false
+	}
+	if err := elgamal.VerifyBallot(poll.Election.PublicKey, poll.PollId, len(poll.Options), *ballot); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Invalid encrypted ballot", gin.H{
+			"reason": err.Error(),
+		})
+		return false
+	}
+
+	// Casting checks again with recordBallotFingerprint, this just turns most copies away early
+	err := BallotFingerprintsColl.FindOne(ctx, bson.M{"_id": ballotFingerprintId(poll.PollId, *ballot)}).Err()
+	if err == nil {
+		sendBallotCopied(c)
+		return false
+	} else if err != mongo.ErrNoDocuments {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// freezeTally seals the poll's ballot log and adds up its encrypted ballots into the tally
+// the trustees decrypt, or returns the tally it already has. It does nothing for polls
+// without encrypted ballots. Ballots accepted from quarantine after that aren't counted
+func freezeTally(ctx context.Context, pollId string) (models.EncryptedTally, error) {
+	var tally models.EncryptedTally
+	err := TalliesColl.FindOne(ctx, bson.M{"_id": pollId}, options.FindOne()).Decode(&tally)
+	if err == nil {
+		return tally, nil
+	} else if err != mongo.ErrNoDocuments {
+		return tally, err
+	}
+
+	var poll models.Poll
+	if err = PollsColl.FindOne(ctx, bson.M{"pollId": pollId}, options.FindOne()).Decode(&poll); err != nil {
+		return tally, err
+	}
+	if poll.Election == nil {
+		return tally, nil
+	}
+	if _, err = appendBallotLog(ctx, pollId, true); err != nil {
+		return tally, err
+	}
+	logged, err := loggedBallots(ctx, pollId)
+	if err != nil {
+		return tally, err
+	}
+
+	var ballots []elgamal.Ballot
+	for _, ballot := range logged {
+		if ballot.Encrypted != nil {
+			ballots = append(ballots, *ballot.Encrypted)
+		}
+	}
+	aggregate, err := elgamal.Aggregate(ballots, len(poll.Options))
+	if err != nil {
+		return tally, err
+	}
+	tally = models.EncryptedTally{
+		PollId:    pollId,
+		Aggregate: aggregate,
+		Ballots:   int64(len(ballots)),
+		Partials:  []elgamal.PartialDecryption{},
+	}
+
+	// Someone else froze it first, theirs is the one the trustees decrypt
+	_, err = TalliesColl.InsertOne(ctx, tally)
+	if mongo.IsDuplicateKeyError(err) {
+		err = TalliesColl.FindOne(ctx, bson.M{"_id": pollId}, options.FindOne()).Decode(&tally)
+	}
+	return tally, err
+}
+
+// decryptedTally is the trustees' decryption of the poll's tally, or errNotDecrypted
+func decryptedTally(ctx context.Context, pollId string) (elgamal.Tally, error) {
+	var tally models.EncryptedTally
+	err := TalliesColl.FindOne(ctx, bson.M{"_id": pollId}, options.FindOne()).Decode(&tally)
+	if err == mongo.ErrNoDocuments || (err == nil && tally.Result == nil) {
+		return elgamal.Tally{}, errNotDecrypted
+	} else if err != nil {
+		return elgamal.Tally{}, err
+	}
+	return *tally.Result, nil
+}
+
+func tallyIndices(tally models.EncryptedTally) []int {
+	indices := []int{}
+	for _, partial := range tally.Partials {
+		indices = append(indices, partial.Index)
+	}
+	return indices
+}
+
+// GetTally publishes a closed encrypted poll's tally for the trustees to decrypt with the
+// trustee command. It holds nothing but the sum of the ballots, so it isn't kept back like
+// the results are
+func GetTally(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": c.Params.ByName("pollId")}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if !checkPollAccess(c, poll, "") {
+		return
+	}
+	if poll.Election == nil {
+		responses.Send(c, http.StatusBadRequest, "Poll doesn't have encrypted ballots", gin.H{})
+		return
+	}
+	if !isPollClosed(poll) {
+		responses.Send(c, http.StatusConflict, "Ballots are tallied once the poll closes", gin.H{})
+		return
+	}
+
+	tally, err := freezeTally(ctx, poll.PollId)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't tally ballots", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	responses.Send(c, http.StatusOK, "Found tally", gin.H{
+		"pollId":    poll.PollId,
+		"election":  poll.Election,
+		"aggregate": tally.Aggregate,
+		"ballots":   tally.Ballots,
+		"received":  tallyIndices(tally),
+		"threshold": poll.Election.Threshold,
+		"decrypted": tally.Result != nil,
+	})
+}
+
+// SubmitDecryption takes a trustee's partial decryption of the tally. Its proofs show it was
+// made with the trustee's share, so it needs no login. Once enough trustees have sent theirs,
+// the results are decrypted and certified
+func SubmitDecryption(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var req requests.SubmitDecryption
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var poll models.Poll
+	err := PollsColl.FindOne(ctx, bson.M{"pollId": req.PollId}, options.FindOne()).Decode(&poll)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Couldn't find poll", gin.H{})
+		return
+	}
+	if poll.Election == nil {
+		responses.Send(c, http.StatusBadRequest, "Poll doesn't have encrypted ballots", gin.H{})
+		return
+	}
+	if !isPollClosed(poll) {
+		responses.Send(c, http.StatusConflict, "Ballots are tallied once the poll closes", gin.H{})
+		return
+	}
+
+	tally, err := freezeTally(ctx, poll.PollId)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't tally ballots", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if err = elgamal.VerifyPartialDecryption(poll.PollId, *poll.Election, tally.Aggregate, req.Partial); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Invalid partial decryption", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	// A trustee sending theirs twice keeps the first one
+	filter := bson.M{"_id": poll.PollId, "partials.index": bson.M{"$ne": req.Partial.Index}}
+	if _, err = TalliesColl.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"partials": req.Partial}}); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't save partial decryption", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if err = TalliesColl.FindOne(ctx, bson.M{"_id": poll.PollId}, options.FindOne()).Decode(&tally); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find tally", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	if tally.Result == nil && len(tally.Partials) >= poll.Election.Threshold {
+		result, err := elgamal.Combine(poll.PollId, *poll.Election, tally.Aggregate, tally.Ballots, tally.Partials)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't decrypt tally", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		now := time.Now()
+		filter := bson.M{"_id": poll.PollId, "result": bson.M{"$exists": false}}
+		_, err = TalliesColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"result": result, "decryptedAt": now}})
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Couldn't save decrypted tally", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		tally.Result = &result
+		if _, err = issueCertificate(ctx, poll.PollId); err != nil {
+			log.Printf("Couldn't issue results certificate of poll [%s]: %s\n", poll.PollId, err.Error())
+		}
+	}
+
+	responses.Send(c, http.StatusOK, "Saved partial decryption", gin.H{
+		"received":  tallyIndices(tally),
+		"threshold": poll.Election.Threshold,
+		"decrypted": tally.Result != nil,
+	})
+}
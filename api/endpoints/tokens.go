@@ -0,0 +1,166 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultTokenExpiryDays int = 30
+	maxTokenExpiryDays     int = 365
+)
+
+var (
+	TokensColl *mongo.Collection = database.Mongo.Database("test").Collection("tokens")
+)
+
+func CreateAPIToken(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.CreateAPIToken
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		responses.Send(c, http.StatusBadRequest, "At least one scope is required", gin.H{
+			"scopes": auth.Scopes,
+		})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsValidScope(scope) {
+			responses.Send(c, http.StatusBadRequest, "Unknown scope", gin.H{
+				"scope":  scope,
+				"scopes": auth.Scopes,
+			})
+			return
+		}
+	}
+
+	expiresInDays := req.ExpiresInDays
+	if expiresInDays <= 0 {
+		expiresInDays = defaultTokenExpiryDays
+	}
+	if expiresInDays > maxTokenExpiryDays {
+		responses.Send(c, http.StatusBadRequest, "Token expiry is too far in the future", gin.H{
+			"maxExpiresInDays": maxTokenExpiryDays,
+		})
+		return
+	}
+
+	token, tokenId, hash := auth.GenerateAPIToken()
+	apiToken := models.APIToken{
+		Name:      req.Name,
+		TokenId:   tokenId,
+		Hash:      hash,
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, expiresInDays),
+		CreatedAt: time.Now(),
+		Owner:     userId,
+	}
+
+	result, err := TokensColl.InsertOne(ctx, apiToken)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't create API token", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	apiToken.Id = result.InsertedID.(primitive.ObjectID)
+	log.Printf("Created API token %s for user %s\n", tokenId, userId.Hex())
+
+	// This is the only time the token itself is ever shown
+	responses.Send(c, http.StatusOK, "API token created", gin.H{
+		"token":    token,
+		"apiToken": apiToken,
+	})
+}
+
+func ListAPITokens(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := TokensColl.Find(ctx, bson.M{"owner": userId}, findOptions)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't find API tokens", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	tokens := []models.APIToken{}
+	if err = cursor.All(ctx, &tokens); err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse API tokens", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	responses.Send(c, http.StatusOK, "Found API tokens for user", gin.H{
+		"tokens": tokens,
+	})
+}
+
+func RevokeAPIToken(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.RevokeAPIToken
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	filter := bson.M{"tokenId": req.TokenId, "owner": userId}
+	result, err := TokensColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't revoke API token", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount != 1 {
+		responses.Send(c, http.StatusNotFound, "API token not found", gin.H{})
+		return
+	}
+	log.Printf("Revoked API token %s of user %s\n", req.TokenId, userId.Hex())
+
+	responses.Send(c, http.StatusOK, "API token revoked", gin.H{})
+}
@@ -0,0 +1,314 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+	"rapidvote/api/responses"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount  int  = 10
+	recoveryCodeLength uint = 10
+)
+
+// Recovery codes are long random strings rather than user-chosen passwords,
+// so a plain SHA-256 is enough to keep them hashed at rest
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code for the user. A TOTP code
+// can't be reused, and a recovery code is consumed once it has been used
+func verifySecondFactor(ctx context.Context, user models.User, code string, recoveryCode string) (bool, error) {
+	if len(recoveryCode) > 0 {
+		result, err := UsersColl.UpdateOne(ctx,
+			bson.M{"_id": user.Id, "recoveryCodes": hashRecoveryCode(recoveryCode)},
+			bson.M{"$pull": bson.M{"recoveryCodes": hashRecoveryCode(recoveryCode)}})
+		if err != nil {
+			return false, err
+		}
+		if result.ModifiedCount == 1 {
+			log.Printf("Recovery code used by user %s\n", user.Id.Hex())
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	valid, counter := auth.ValidateTOTP(user.TOTPSecret, code, user.TOTPLastCounter)
+	if !valid {
+		return false, nil
+	}
+
+	// Only accept the code if no concurrent request has used this time step already
+	result, err := UsersColl.UpdateOne(ctx,
+		bson.M{"_id": user.Id, "totpLastCounter": bson.M{"$not": bson.M{"$gte": counter}}},
+		bson.M{"$set": bson.M{"totpLastCounter": counter}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// EnrollTOTP starts 2FA enrollment by generating a secret. 2FA isn't enforced until
+// the first code is confirmed with VerifyTOTP
+func EnrollTOTP(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Account not found", gin.H{})
+		return
+	}
+	if user.TOTPEnabled {
+		responses.Send(c, http.StatusBadRequest, "Two-factor authentication is already enabled", gin.H{})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't generate TOTP secret", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	_, err = UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{
+		"totpSecret":      secret,
+		"totpEnabled":     false,
+		"totpLastCounter": 0,
+	}})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't start enrollment", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	log.Printf("Started TOTP enrollment for user %s\n", userId.Hex())
+
+	responses.Send(c, http.StatusOK, "Scan the provisioning URI with an authenticator app", gin.H{
+		"secret":          secret,
+		"provisioningUri": auth.TOTPProvisioningURI(secret, user.Email),
+	})
+}
+
+// VerifyTOTP confirms enrollment with the first code from the authenticator app, turns
+// on 2FA and hands out the recovery codes. They are only ever shown here
+func VerifyTOTP(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.TOTPVerify
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Account not found", gin.H{})
+		return
+	}
+	if user.TOTPEnabled || len(user.TOTPSecret) == 0 {
+		responses.Send(c, http.StatusBadRequest, "No two-factor enrollment in progress", gin.H{})
+		return
+	}
+
+	valid, err := verifySecondFactor(ctx, user, req.Code, "")
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if !valid {
+		responses.Send(c, http.StatusUnauthorized, "Invalid code", gin.H{})
+		return
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	hashedCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		code := strings.ToLower(util.GenSecureRandomString(recoveryCodeLength))
+		recoveryCodes[i] = code[:5] + "-" + code[5:]
+		hashedCodes[i] = hashRecoveryCode(code)
+	}
+
+	_, err = UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{
+		"totpEnabled":   true,
+		"recoveryCodes": hashedCodes,
+	}})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't enable two-factor authentication", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	log.Printf("Enabled TOTP for user %s\n", userId.Hex())
+
+	responses.Send(c, http.StatusOK, "Two-factor authentication enabled", gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+// DisableTOTP turns 2FA off. It requires the password (when the account has one)
+// and a current code or a recovery code
+func DisableTOTP(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req requests.TOTPDisable
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var user models.User
+	err := UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusNotFound, "Account not found", gin.H{})
+		return
+	}
+	if !user.TOTPEnabled {
+		responses.Send(c, http.StatusBadRequest, "Two-factor authentication is not enabled", gin.H{})
+		return
+	}
+
+	if len(user.Password) > 0 {
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+		if err != nil {
+			responses.Send(c, http.StatusUnauthorized, "Wrong password", gin.H{})
+			return
+		}
+	}
+
+	valid, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if !valid {
+		responses.Send(c, http.StatusUnauthorized, "Invalid code", gin.H{})
+		return
+	}
+
+	_, err = UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{
+		"$set":   bson.M{"totpEnabled": false},
+		"$unset": bson.M{"totpSecret": "", "totpLastCounter": "", "recoveryCodes": ""},
+	})
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Couldn't disable two-factor authentication", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	log.Printf("Disabled TOTP for user %s\n", userId.Hex())
+
+	responses.Send(c, http.StatusOK, "Two-factor authentication disabled", gin.H{})
+}
+
+// LoginTOTP is the second step of LoginUser for accounts with 2FA. It trades the challenge
+// token and a TOTP or recovery code for a session
+func LoginTOTP(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.TOTPLogin
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	challengeClaims, err := auth.ParseToken(req.ChallengeToken, auth.TokenTypeChallenge)
+	if err == nil && challengeClaims.Audience == auth.ReactivationAudience {
+		err = errors.New("reactivation tokens can't be used to log in")
+	}
+	if err != nil {
+		responses.Send(c, http.StatusUnauthorized, "Login challenge expired or invalid", gin.H{
+			"reason":         err.Error(),
+			"reauthRequired": true,
+		})
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(challengeClaims.Issuer)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Malformed userID from claims", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var user models.User
+	err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil || !user.TOTPEnabled {
+		responses.Send(c, http.StatusUnauthorized, "Login challenge expired or invalid", gin.H{
+			"reauthRequired": true,
+		})
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	if !checkLoginLockout(c, ctx, user.Email) {
+		return
+	}
+
+	valid, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+	if !valid {
+		recordLoginFailures(ctx, user.Email, clientAddr(c))
+		responses.Send(c, http.StatusUnauthorized, "Invalid code", gin.H{})
+		return
+	}
+
+	resetLoginFailures(ctx, user.Email)
+	startSession(c, user)
+}
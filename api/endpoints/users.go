@@ -2,6 +2,8 @@ package endpoints
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -13,7 +15,6 @@ import (
 	"rapidvote/api/responses"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -43,23 +44,90 @@ func LoginUser(c *gin.Context) {
 	}
 	log.Printf("Verifying Login: %+v\n", login)
 
-	// Check if user exists with the given email
+	user, ok := checkPassword(c, ctx, login)
+	if !ok {
+		return
+	}
+
+	// Accounts with 2FA get a challenge token instead, which is traded for a session in LoginTOTP
+	if user.TOTPEnabled {
+		challengeToken, err := auth.GenerateChallengeToken(user.Id.Hex())
+		if err != nil {
+			log.Printf("Couldn't generate challenge token: %s\n", err.Error())
+			responses.Send(c, http.StatusInternalServerError, "Couldn't generate JWT", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		responses.Send(c, http.StatusOK, "Second factor required", gin.H{
+			"twoFactorRequired": true,
+			"challengeToken": challengeToken,
+		})
+		return
+	}
+
+	resetLoginFailures(ctx, user.Email)
+	startSession(c, user)
+}
+
+// checkPassword verifies an email/password combination, subject to the login lockout.
+// If it returns false, an error response has already been sent
+func checkPassword(c *gin.Context, ctx context.Context, login requests.Login) (models.User, bool) {
+	if !checkLoginLockout(c, ctx, login.Email) {
+		return models.User{}, false
+	}
+
+	// Check if user exists with the given email. Unknown emails still go through a
+	// password comparison, so they can't be told apart from wrong passwords
 	var user models.User
 	err := UsersColl.FindOne(ctx, bson.M{"email": login.Email}, options.FindOne()).Decode(&user)
-	if err != nil {
-		responses.Send(c, http.StatusNotFound, "Account doesn't exist with given email", gin.H{})
-		return 
+	passwordHash := dummyPasswordHash
+	if err == nil && len(user.Password) > 0 {
+		passwordHash = []byte(user.Password)
 	}
 
 	// Compare hashes of user's password. If they don't match, send HTTP error code 401 (Unauthorized)
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(login.Password))
-	if err != nil { // If err != nil, that means it doesn't match
+	passwordErr := bcrypt.CompareHashAndPassword(passwordHash, []byte(login.Password))
+	if err != nil || passwordErr != nil || len(user.Password) == 0 {
+		recordLoginFailures(ctx, login.Email, clientAddr(c))
 		responses.Send(c, http.StatusUnauthorized, "Wrong email/password combination", gin.H{})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// startSession generates access & refresh JWTs for the user, sets the accessToken cookie
+// and sends back the session the frontend keeps in localStorage
+func startSession(c *gin.Context, user models.User) {
+	if user.Disabled {
+		responses.Send(c, http.StatusForbidden, "Account has been disabled", gin.H{})
+		return
+	}
+	// Every way of logging in ends up here, so a deactivated user gets to reactivate only
+	// after passing the same steps as logging in: a password and second factor, or a provider
+	if user.DeactivatedAt != nil {
+		reactivationToken, err := auth.GenerateReactivationToken(user.Id.Hex())
+		if err != nil {
+			log.Printf("Couldn't generate reactivation token: %s\n", err.Error())
+			responses.Send(c, http.StatusInternalServerError, "Couldn't generate JWT", gin.H{
+				"reason": err.Error(),
+			})
+			return
+		}
+		responses.Send(c, http.StatusForbidden, "Account is deactivated", gin.H{
+			"reactivatable":     true,
+			"reactivationToken": reactivationToken,
+			"purgeAt":           user.PurgeAt,
+		})
 		return
 	}
 
-	// Generate access & refresh JWTs
-	tokens, err := auth.GenerateTokens(user.Id.Hex())
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	tokens, err := auth.GenerateTokens(user.Id.Hex(), role)
 	if err != nil {
 		log.Printf("Couldn't generate JWT: %s\n", err.Error())
 		responses.Send(c, http.StatusInternalServerError, "Couldn't generate JWT", gin.H{
@@ -75,10 +143,44 @@ func LoginUser(c *gin.Context) {
 	responses.Send(c, http.StatusOK, "Successfully logged in", gin.H{
 		"userEmail": user.Email,
 		"userId": user.Id,
+		"role": role,
 		"refreshToken": tokens.RefreshToken,
 	})
 }
 
+// authenticatedUserId returns the ID of the user authenticated by the JWT middleware.
+// If it returns false, an error response has already been sent
+func authenticatedUserId(c *gin.Context) (primitive.ObjectID, bool) {
+	accessClaims, exists := c.Get("accessClaims")
+	if !exists {
+		responses.Send(c, http.StatusUnauthorized, "Couldn't get accessClaims", gin.H{})
+		return primitive.NilObjectID, false
+	}
+
+	claims := accessClaims.(*auth.Claims)
+	userId, err := primitive.ObjectIDFromHex(claims.Issuer)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Malformed userID from claims", gin.H{
+			"reason": err.Error(),
+		})
+		return primitive.NilObjectID, false
+	}
+	return userId, true
+}
+
+// passwordFieldErrors checks a password against auth.Policy, and reports every
+// violation as an error on `field`
+func passwordFieldErrors(field string, password string, email string) []responses.FieldError {
+	var fieldErrors []responses.FieldError
+	for _, violation := range auth.Policy.Validate(password, email) {
+		fieldErrors = append(fieldErrors, responses.FieldError{
+			Field:   field,
+			Message: "Password " + violation,
+		})
+	}
+	return fieldErrors
+}
+
 func LogoutUser(c *gin.Context) {
 	// TODO: Change the domain in production, and set secure to true
 	// Make the cookie expire for the client
@@ -101,6 +203,11 @@ func RegisterUser(c *gin.Context) {
 	}
 	log.Printf("Got Register: %+v\n", register)
 
+	if fieldErrors := passwordFieldErrors("password", register.Password, register.Email); len(fieldErrors) > 0 {
+		responses.SendFieldErrors(c, "Password doesn't meet the password policy", fieldErrors)
+		return
+	}
+
 	// Check if user with this email has already signed up
 	var user models.User
 	err := UsersColl.FindOne(ctx, bson.M{"email": register.Email}, options.FindOne()).Decode(&user)
@@ -148,7 +255,7 @@ func ResetUserEmail(c *gin.Context) {
 		return
 	}
 
-	claims := accessClaims.(*jwt.StandardClaims)
+	claims := accessClaims.(*auth.Claims)
 	log.Printf("Got claims for user: %+v\n", claims)
 
 	// Parse and unmarshal the incoming request into `models.Login` type
@@ -203,7 +310,7 @@ func ResetUserEmail(c *gin.Context) {
 
 	log.Printf("Resetting email for user: %s\n", user.Id)
 
-	result,err := UsersColl.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.D{{"$set",bson.D{{"email", reset.NewEmail}}}})
+	result,err := UsersColl.UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$set": bson.M{"email": reset.NewEmail, "emailVerified": false}})
 	if result.MatchedCount != 1 || err != nil {
 		responses.Send(c, http.StatusNotFound, "Account doesn't exist", gin.H{})
 		return 
@@ -221,7 +328,7 @@ func ResetUserPassword(c *gin.Context) {
 		return
 	}
 
-	claims := accessClaims.(*jwt.StandardClaims)
+	claims := accessClaims.(*auth.Claims)
 	log.Printf("Got claims for user: %+v\n", claims)
 
 	// Parse and unmarshal the incoming request into `models.Login` type
@@ -253,6 +360,11 @@ func ResetUserPassword(c *gin.Context) {
 		return
 	}
 
+	if fieldErrors := passwordFieldErrors("newPassword", reset.NewPassword, user.Email); len(fieldErrors) > 0 {
+		responses.SendFieldErrors(c, "Password doesn't meet the password policy", fieldErrors)
+		return
+	}
+
 	hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(reset.NewPassword), saltRounds)
 	if err != nil {
 		log.Printf("Couldn't hash new password: %s\n", err.Error())
@@ -264,7 +376,7 @@ func ResetUserPassword(c *gin.Context) {
 
 	log.Printf("Resetting password for user: %s\n", userId)
 
-	results,err := UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.D{{"$set",bson.D{{"password", hashedNewPassword}}}})
+	results,err := UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{"password": string(hashedNewPassword)}})
 	if results.MatchedCount != 1 || err != nil {
 		responses.Send(c, http.StatusNotFound, "Account doesn't exist", gin.H{})
 		return 
@@ -283,7 +395,7 @@ func FetchPolls(c *gin.Context) {
 		return
 	}
 
-	claims := accessClaims.(*jwt.StandardClaims)
+	claims := accessClaims.(*auth.Claims)
 	log.Printf("Got claims for user: %+v\n", claims)
 
 	userId, err := primitive.ObjectIDFromHex(claims.Issuer)
@@ -326,10 +438,14 @@ func FetchPolls(c *gin.Context) {
 	}
 
 	responses.Send(c, http.StatusOK, "Found polls for user", gin.H{
-		"polls": polls,
+		"polls":   polls,
+		"creator": creatorProfile(ctx, userId),
 	})
 }
 
+// DeactivateUser soft-disables the account. It can be reactivated with ReactivateUser during
+// the grace period, after which the purge job removes it and handles the user's polls and
+// votes as they chose here
 func DeactivateUser(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -340,7 +456,7 @@ func DeactivateUser(c *gin.Context) {
 		return
 	}
 
-	claims := accessClaims.(*jwt.StandardClaims)
+	claims := accessClaims.(*auth.Claims)
 	log.Printf("DeactivateUser: got claims for user: %+v\n", claims)
 
 	userId, err := primitive.ObjectIDFromHex(claims.Issuer)
@@ -350,16 +466,126 @@ func DeactivateUser(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Deactivating user: %s\n", userId)
 
-	// Delete User document in Users collection
-	_, err = UsersColl.DeleteOne(ctx, bson.M{"_id": userId}, options.Delete())
+	// An empty body deactivates with the defaults
+	var req requests.Deactivate
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	choices := models.DeactivationChoices{
+		Polls:     req.Polls,
+		KeepVotes: req.KeepVotes == nil || *req.KeepVotes,
+	}
+	switch choices.Polls {
+	case "":
+		choices.Polls = models.PollsAnonymize
+	case models.PollsDelete, models.PollsAnonymize:
+	case models.PollsTransfer:
+		var recipient models.User
+		err = UsersColl.FindOne(ctx, bson.M{"email": req.TransferTo}, options.FindOne()).Decode(&recipient)
+		if err != nil || recipient.Id == userId || recipient.Disabled || recipient.DeactivatedAt != nil {
+			responses.SendFieldErrors(c, "Can't transfer polls to that user", []responses.FieldError{{
+				Field:   "transferTo",
+				Message: "No active account exists with the given email",
+			}})
+			return
+		}
+		choices.TransferTo = recipient.Id
+	default:
+		responses.SendFieldErrors(c, "Unknown option for polls", []responses.FieldError{{
+			Field:   "polls",
+			Message: "Must be one of delete, transfer or anonymize",
+		}})
+		return
+	}
+
+	now := time.Now()
+	purgeAt := now.AddDate(0, 0, deactivationGraceDays)
+	log.Printf("Deactivating user %s until %s: %+v\n", userId, purgeAt, choices)
+
+	result, err := UsersColl.UpdateOne(ctx, bson.M{"_id": userId}, bson.M{"$set": bson.M{
+		"deactivatedAt": now,
+		"purgeAt":       purgeAt,
+		"deactivation":  choices,
+	}})
 	if err != nil {
 		responses.Send(c, http.StatusInternalServerError, "Couldn't deactivate user", gin.H{
 			"reason": err.Error(),
 		})
 		return
 	}
+	if result.MatchedCount != 1 {
+		responses.Send(c, http.StatusNotFound, "Account doesn't exist", gin.H{})
+		return
+	}
+
+	// TODO: Change the domain in production, and set secure to true
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("accessToken", "", 0, "/", "", false, true)
+
+	responses.Send(c, http.StatusOK, "User account successfully deactivated", gin.H{
+		"purgeAt": purgeAt,
+	})
+}
+
+// ReactivateUser undoes DeactivateUser, as long as the account hasn't been purged yet. It takes
+// the reactivation token startSession hands out instead of a session to deactivated users
+func ReactivateUser(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req requests.Reactivate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responses.Send(c, http.StatusBadRequest, "Couldn't parse request as JSON", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	reactivationClaims, err := auth.ParseToken(req.ReactivationToken, auth.TokenTypeChallenge)
+	if err == nil && !reactivationClaims.VerifyAudience(auth.ReactivationAudience, true) {
+		err = errors.New("not a reactivation token")
+	}
+	if err != nil {
+		responses.Send(c, http.StatusUnauthorized, "Reactivation token expired or invalid", gin.H{
+			"reason":         err.Error(),
+			"reauthRequired": true,
+		})
+		return
+	}
+	userId, err := primitive.ObjectIDFromHex(reactivationClaims.Issuer)
+	if err != nil {
+		responses.Send(c, http.StatusInternalServerError, "Malformed userID from claims", gin.H{
+			"reason": err.Error(),
+		})
+		return
+	}
+
+	var user models.User
+	err = UsersColl.FindOne(ctx, bson.M{"_id": userId}, options.FindOne()).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusGone, "Account can no longer be reactivated", gin.H{})
+		return
+	}
+
+	if user.DeactivatedAt == nil {
+		responses.Send(c, http.StatusBadRequest, "Account isn't deactivated", gin.H{})
+		return
+	}
+
+	err = UsersColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": user.Id, "purgeAt": bson.M{"$gt": time.Now()}},
+		bson.M{"$unset": bson.M{"deactivatedAt": "", "purgeAt": "", "deactivation": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		responses.Send(c, http.StatusGone, "Account can no longer be reactivated", gin.H{})
+		return
+	}
+	log.Printf("Reactivated user %s\n", user.Id.Hex())
 
-	responses.Send(c, http.StatusOK, "User account successfully deactivated", gin.H{})
+	startSession(c, user)
 }
@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// resultsVisibility is the poll's setting, with legacy polls falling back to always
+func resultsVisibility(poll models.Poll) string {
+	if len(poll.ResultsVisibility) == 0 {
+		return models.ResultsAlways
+	}
+	return poll.ResultsVisibility
+}
+
+func isPollClosed(poll models.Poll) bool {
+	return !poll.Status || poll.Expiration.Before(time.Now())
+}
+
+// callerUserId is the user authenticated with a session or API token. Unlike requestUserId,
+// it never trusts a user ID sent in the request
+func callerUserId(c *gin.Context) (primitive.ObjectID, *auth.Claims) {
+	accessClaims, exists := c.Get("accessClaims")
+	if !exists {
+		return primitive.NilObjectID, nil
+	}
+	claims := accessClaims.(*auth.Claims)
+	userId, err := primitive.ObjectIDFromHex(claims.Issuer)
+	if err != nil {
+		return primitive.NilObjectID, nil
+	}
+	return userId, claims
+}
+
+// canSeeResults reports whether the caller may see the poll's results, given whether they
+// already voted on it
+func canSeeResults(c *gin.Context, poll models.Poll, voted bool) bool {
+	userId, claims := callerUserId(c)
+	if claims != nil && ((userId == poll.Creator && !poll.Creator.IsZero()) || models.HasRole(claims.Role, models.RoleModerator)) {
+		return true
+	}
+
+	switch resultsVisibility(poll) {
+	case models.ResultsAlways:
+		return true
+	case models.ResultsAfterVote:
+		return voted || isPollClosed(poll)
+	case models.ResultsAfterClose:
+		return isPollClosed(poll)
+	default:
+		return false
+	}
+}
+
+// callerHasVoted reports whether the caller already voted on the poll, going by their
+// account, their device, their IP address, their invitation or their credential
+func callerHasVoted(c *gin.Context, ctx context.Context, poll models.Poll, invitation string) (bool, error) {
+	if poll.InviteOnly {
+		reason, err := invitationIneligibility(ctx, poll, invitation)
+		return reason == ReasonAlreadyVoted, err
+	}
+	// Ballots cast with a credential can't be tied to anyone, getting the credential is as
+	// close as it gets
+	if poll.Credentials != nil {
+		userId, _ := callerUserId(c)
+		if userId.IsZero() {
+			return false, nil
+		}
+		err := CredentialIssuancesColl.FindOne(ctx, bson.M{"_id": credentialIssuanceId(poll.PollId, userId)}, options.FindOne()).Err()
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	// Polls without dedupe still know who voted from which account and device
+	byVoter := poll
+	if dedupePolicy(poll) == models.DedupeNone {
+		byVoter.DedupePolicy = models.DedupeDevice
+	}
+	userId, _ := callerUserId(c)
+	deviceId, _ := voterDevice(c)
+	filter := voterFilter(byVoter, userId, deviceId, clientAddr(c))
+	if filter == nil {
+		return false, nil
+	}
+
+	err := ParticipationsColl.FindOne(ctx, filter, options.FindOne()).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkResultsVisible makes sure the caller may see the poll's results, or anything that gives
+// them away. If it returns false, an error response has already been sent
+func checkResultsVisible(c *gin.Context, ctx context.Context, poll models.Poll, invitation string) bool {
+	voted := false
+	if resultsVisibility(poll) == models.ResultsAfterVote && !isPollClosed(poll) {
+		var err error
+		voted, err = callerHasVoted(c, ctx, poll, invitation)
+		if err != nil {
+			responses.Send(c, http.StatusInternalServerError, "Something went wrong", gin.H{
+				"reason": err.Error(),
+			})
+			return false
+		}
+	}
+	if !canSeeResults(c, poll, voted) {
+		responses.Send(c, http.StatusForbidden, "Results of this poll aren't visible yet", gin.H{
+			"resultsVisibility": resultsVisibility(poll),
+		})
+		return false
+	}
+	return true
+}
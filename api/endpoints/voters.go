@@ -0,0 +1,122 @@
+package endpoints
+
+import (
+	"log"
+	"net/http"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/util"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	voterCookie = "voterToken"
+
+	voterDeviceIdLength uint = 24
+	voterCookieMaxAge   int  = 365 * 24 * 60 * 60
+)
+
+// voterDevice returns the device ID from the request's signed voter cookie
+func voterDevice(c *gin.Context) (string, bool) {
+	token, err := c.Cookie(voterCookie)
+	if err != nil || len(token) == 0 {
+		return "", false
+	}
+	claims, err := auth.ParseToken(token, auth.TokenTypeVoter)
+	if err != nil || len(claims.Subject) == 0 {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// ensureVoterDevice returns the request's device ID, issuing a new voter cookie
+// if the request didn't carry a valid one
+func ensureVoterDevice(c *gin.Context) string {
+	if deviceId, ok := voterDevice(c); ok {
+		return deviceId
+	}
+
+	deviceId := util.GenSecureRandomString(voterDeviceIdLength)
+	token, err := auth.GenerateVoterToken(deviceId)
+	if err != nil {
+		log.Printf("Couldn't issue voter token: %s\n", err.Error())
+		return ""
+	}
+
+	// TODO: Change the domain in production, and set secure to true
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(voterCookie, token, voterCookieMaxAge, "/", "", false, true)
+	return deviceId
+}
+
+// dedupePolicy is the poll's policy, with legacy polls falling back to IP
+func dedupePolicy(poll models.Poll) string {
+	if len(poll.DedupePolicy) == 0 {
+		return models.DedupeIP
+	}
+	return poll.DedupePolicy
+}
+
+// voterDedupeKeys are the keys that stop this voter from voting on the poll again, the
+// same ones voterFilter looks for
+func voterDedupeKeys(poll models.Poll, userId primitive.ObjectID, deviceId string, addr string) []string {
+	account := poll.PollId + "/account/" + userId.Hex()
+	switch dedupePolicy(poll) {
+	case models.DedupeNone:
+		return nil
+	case models.DedupeAccount:
+		return []string{account}
+	case models.DedupeDevice:
+		var keys []string
+		if !userId.IsZero() {
+			keys = append(keys, account)
+		}
+		if len(deviceId) > 0 {
+			keys = append(keys, poll.PollId+"/device/"+deviceId)
+		}
+		return keys
+	default:
+		if userId.IsZero() {
+			return []string{poll.PollId + "/addr/" + addr}
+		}
+		return []string{account}
+	}
+}
+
+// voterFilter matches the votes that stop this voter from voting on the poll again,
+// according to the poll's dedupe policy. It returns nil when nothing does
+func voterFilter(poll models.Poll, userId primitive.ObjectID, deviceId string, addr string) bson.M {
+	filter := bson.M{"pollId": poll.PollId}
+
+	switch dedupePolicy(poll) {
+	case models.DedupeNone:
+		return nil
+	case models.DedupeAccount:
+		filter["voterId"] = userId
+	case models.DedupeDevice:
+		// Logging out mustn't give a device another vote, and neither must switching devices
+		// give an account one
+		var voters bson.A
+		if !userId.IsZero() {
+			voters = append(voters, bson.M{"voterId": userId})
+		}
+		if len(deviceId) > 0 {
+			voters = append(voters, bson.M{"voterDevice": deviceId})
+		}
+		if len(voters) == 0 {
+			return nil
+		}
+		filter["$or"] = voters
+	default:
+		if userId.IsZero() {
+			filter["voterAddr"] = addr
+		} else {
+			filter["voterId"] = userId
+		}
+	}
+	return filter
+}
@@ -0,0 +1,130 @@
+//go:build integration
+// +build integration
+
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"rapidvote/api/models"
+	"rapidvote/api/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestVoterDedupeKeys(t *testing.T) {
+	userId, _ := primitive.ObjectIDFromHex("62213d7a3e6b5b0c9c7d1a01")
+	account := "abcd1234/account/62213d7a3e6b5b0c9c7d1a01"
+	device := "abcd1234/device/dev"
+	addr := "abcd1234/addr/192.0.2.1"
+
+	tests := []struct {
+		policy string
+		userId primitive.ObjectID
+		device string
+		want   []string
+	}{
+		{models.DedupeNone, userId, "dev", nil},
+		{models.DedupeAccount, userId, "dev", []string{account}},
+		{models.DedupeDevice, userId, "dev", []string{account, device}},
+		{models.DedupeDevice, primitive.NilObjectID, "dev", []string{device}},
+		{models.DedupeDevice, primitive.NilObjectID, "", nil},
+		{models.DedupeIP, userId, "dev", []string{account}},
+		{models.DedupeIP, primitive.NilObjectID, "dev", []string{addr}},
+		// Polls from before dedupe policies go by IP
+		{"", primitive.NilObjectID, "", []string{addr}},
+	}
+	for _, test := range tests {
+		poll := models.Poll{PollId: "abcd1234", DedupePolicy: test.policy}
+		keys := voterDedupeKeys(poll, test.userId, test.device, "192.0.2.1")
+		if !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("%q policy, user %v, device %q: keys = %v, want %v", test.policy, !test.userId.IsZero(), test.device, keys, test.want)
+		}
+		// Whatever has keys is found again by voterFilter, and the other way around
+		if filter := voterFilter(poll, test.userId, test.device, "192.0.2.1"); (filter == nil) != (keys == nil) {
+			t.Errorf("%q policy, user %v, device %q: filter = %v with keys %v", test.policy, !test.userId.IsZero(), test.device, filter, keys)
+		}
+	}
+}
+
+func TestVoteDedupe(t *testing.T) {
+	requireMongo(t)
+	poll := createTestPoll(t, models.Poll{DedupePolicy: models.DedupeIP})
+	voter := createTestUser(t, models.User{Email: "voter@example.com"})
+	vote := requests.VotePoll{PollId: poll.PollId, Choice: 1}
+
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote); recorder.Code != http.StatusOK {
+		t.Fatalf("first vote = %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote); recorder.Code != http.StatusBadRequest {
+		t.Errorf("second vote from the same address = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	// A user ID in the body is no session: it neither gets another vote from the address,
+	// nor uses up the vote of the user it names
+	withUserId := map[string]interface{}{"pollId": poll.PollId, "choice": 0, "userId": voter.Id.Hex()}
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", withUserId); recorder.Code != http.StatusBadRequest {
+		t.Errorf("vote naming another user in the body = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	if recorder := postJSON(VotePoll, primitive.NilObjectID, "192.0.2.2", withUserId); recorder.Code != http.StatusOK {
+		t.Errorf("vote naming another user from a new address = %d: %s", recorder.Code, recorder.Body.String())
+	}
+	count, err := ParticipationsColl.CountDocuments(context.Background(), bson.M{"pollId": poll.PollId, "voterId": voter.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d votes were recorded for the user named in the body", count)
+	}
+
+	// Logged in voters are told apart by their account, wherever they vote from
+	if recorder := postJSON(VotePoll, voter.Id, "192.0.2.1", vote); recorder.Code != http.StatusOK {
+		t.Errorf("vote with a session = %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := postJSON(VotePoll, voter.Id, "192.0.2.3", vote); recorder.Code != http.StatusBadRequest {
+		t.Errorf("second vote with a session = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestConcurrentVotesCountOnce(t *testing.T) {
+	requireMongo(t)
+	poll := createTestPoll(t, models.Poll{DedupePolicy: models.DedupeIP})
+	vote := requests.VotePoll{PollId: poll.PollId, Choice: 0}
+
+	const voters = 8
+	codes := make([]int, voters)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = postJSON(VotePoll, primitive.NilObjectID, "192.0.2.1", vote).Code
+		}(i)
+	}
+	wg.Wait()
+
+	cast := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			cast++
+		}
+	}
+	if cast != 1 {
+		t.Errorf("%d of %d votes sent at once from one address were cast, want 1: %v", cast, voters, codes)
+	}
+	for name, coll := range map[string]*mongo.Collection{"participations": ParticipationsColl, "ballots": BallotsColl} {
+		count, err := coll.CountDocuments(context.Background(), bson.M{"pollId": poll.PollId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%d %s recorded, want 1", count, name)
+		}
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/joho/godotenv v1.4.0
 	go.mongodb.org/mongo-driver v1.8.2
 	golang.org/x/crypto v0.0.0-20220131195533-30dcbda58838
+	golang.org/x/image v0.0.0-20220413100746-70e8d0d3baa9
 )
 
 require (
@@ -0,0 +1,75 @@
+// Package mailer sends plain text emails through the SMTP server configured with the
+// `SMTP_*` environment variables. Without `SMTP_HOST`, emails are only logged, which is
+// enough for development
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+var (
+	smtpHost     = os.Getenv("SMTP_HOST")
+	smtpPort     = envOr("SMTP_PORT", "587")
+	smtpUsername = os.Getenv("SMTP_USERNAME")
+	smtpPassword = os.Getenv("SMTP_PASSWORD")
+	smtpFrom     = envOr("SMTP_FROM", "RapidVote <no-reply@rapidvote.local>")
+)
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Enabled reports whether emails are actually sent, rather than logged
+func Enabled() bool {
+	return smtpHost != ""
+}
+
+// Send emails `body` to a single recipient
+func Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	if !Enabled() {
+		log.Printf("SMTP_HOST isn't set, not sending email to %s\nSubject: %s\n\n%s\n", to, subject, body)
+		return nil
+	}
+
+	message := strings.Join([]string{
+		"From: " + smtpFrom,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if smtpUsername != "" {
+		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+	}
+	return smtp.SendMail(net.JoinHostPort(smtpHost, smtpPort), auth, envelopeAddress(smtpFrom), []string{to}, []byte(message))
+}
+
+// envelopeAddress is the bare address in `Name <address>`
+func envelopeAddress(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			return from[start+1 : end]
+		}
+	}
+	return from
+}
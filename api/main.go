@@ -1,11 +1,14 @@
 package main
 
 import (
+	"log"
 	"math/rand"
 	"time"
 
+	"rapidvote/api/auth"
 	"rapidvote/api/endpoints"
 	"rapidvote/api/middleware"
+	"rapidvote/api/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,31 +16,120 @@ import (
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
+	endpoints.MigrateLegacyVotes()
+	endpoints.StartPurgeJob(time.Hour)
+
 	r := gin.Default()
+	if err := r.SetTrustedProxies(middleware.TrustedProxyCIDRs()); err != nil {
+		log.Fatal(err)
+	}
 	r.Use(middleware.CORS())
+	r.Use(middleware.ClientIP())
 
 	api := r.Group("/api")
 
+	// Rate limits, configured per group of endpoints
+	viewLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route: "view",
+		PerIP: middleware.Limit{Requests: 120, Per: time.Minute},
+	})
+	voteLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route:    "vote",
+		PerIP:    middleware.Limit{Requests: 30, Per: time.Minute},
+		PerUser:  middleware.Limit{Requests: 30, Per: time.Minute},
+		PerRoute: middleware.Limit{Requests: 1000, Per: time.Second},
+	})
+	createLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route:    "create",
+		PerIP:    middleware.Limit{Requests: 20, Per: time.Hour},
+		PerUser:  middleware.Limit{Requests: 20, Per: time.Hour},
+		PerRoute: middleware.Limit{Requests: 100, Per: time.Minute},
+	})
+	loginLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route: "login",
+		PerIP: middleware.Limit{Requests: 20, Per: time.Minute},
+	})
+	unlockLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route: "unlock",
+		PerIP: middleware.Limit{Requests: 10, Per: time.Minute},
+	})
+	registerLimit := middleware.RateLimit(middleware.RateLimitConfig{
+		Route: "register",
+		PerIP: middleware.Limit{Requests: 5, Per: time.Hour},
+	})
+
 	// Poll endpoints
 	polls := api.Group("/polls")
 	{
-		polls.POST("/view/:pollId", endpoints.ViewPoll)
-		polls.GET("/results/:pollId", endpoints.GetPollResult)
-		polls.POST("/vote", endpoints.VotePoll)
-		polls.POST("/create", endpoints.CreatePoll)
-		polls.POST("/close", endpoints.ClosePoll)
+		polls.POST("/view/:pollId", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.ViewPoll)
+		polls.GET("/results/:pollId", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.GetPollResult)
+		polls.POST("/vote", middleware.OptionalAuth(auth.ScopeVotesWrite), voteLimit, endpoints.VotePoll)
+		polls.POST("/create", middleware.OptionalAuth(auth.ScopePollsWrite), createLimit, endpoints.CreatePoll)
+		polls.GET("/log/:pollId", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.GetBallotLog)
+		polls.POST("/receipt", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.CheckReceipt)
+		polls.GET("/certificates/:pollId", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.GetCertificate)
+		polls.POST("/certificates/verify", viewLimit, endpoints.VerifyCertificate)
+		polls.GET("/tally/:pollId", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.GetTally)
+		polls.POST("/tally/decrypt", viewLimit, endpoints.SubmitDecryption)
+		polls.POST("/credentials/issue", middleware.Auth(auth.ScopeVotesWrite), voteLimit, endpoints.IssueCredential)
+		polls.POST("/voters", middleware.OptionalAuth(auth.ScopePollsRead), viewLimit, endpoints.ListVoters)
+		polls.POST("/unlock", middleware.OptionalAuth(auth.ScopePollsRead), unlockLimit, endpoints.UnlockPoll)
+		polls.POST("/close", middleware.Auth(auth.ScopePollsWrite), endpoints.ClosePoll)
+		polls.POST("/invitations", middleware.Auth(auth.ScopePollsWrite), endpoints.ListInvitations)
+		polls.POST("/invitations/upload", middleware.Auth(auth.ScopePollsWrite), endpoints.InviteVoters)
+		polls.POST("/quarantine", middleware.Auth(auth.ScopePollsWrite), endpoints.QuarantinedVotes)
+		polls.POST("/quarantine/review", middleware.Auth(auth.ScopePollsWrite), endpoints.ReviewVotes)
 	}
 
 	// User endpoints
 	users := api.Group("/users")
 	{
-		users.POST("/register", endpoints.RegisterUser)
-		users.POST("/login", endpoints.LoginUser)
-		users.POST("/logout", endpoints.LogoutUser).Use(middleware.JWT())
-		users.POST("/reset/email", endpoints.ResetUserEmail).Use(middleware.JWT())
-		users.POST("/reset/password", endpoints.ResetUserPassword).Use(middleware.JWT())
-		users.POST("/polls", endpoints.FetchPolls).Use(middleware.JWT())
-		users.POST("/deactivate", endpoints.DeactivateUser).Use(middleware.JWT())
+		users.POST("/register", registerLimit, endpoints.RegisterUser)
+		users.POST("/login", loginLimit, endpoints.LoginUser)
+		users.POST("/login/2fa", loginLimit, endpoints.LoginTOTP)
+		users.POST("/oidc/:provider/login", loginLimit, endpoints.OIDCLogin)
+		users.POST("/oidc/:provider/callback", loginLimit, endpoints.OIDCCallback)
+		users.POST("/logout", endpoints.LogoutUser)
+		users.POST("/reactivate", loginLimit, endpoints.ReactivateUser)
+		users.POST("/polls", middleware.Auth(auth.ScopePollsRead), endpoints.FetchPolls)
+
+		// Account management is only available to browser sessions, not API tokens
+		account := users.Group("", middleware.JWT())
+		{
+			account.POST("/reset/email", endpoints.ResetUserEmail)
+			account.POST("/reset/password", endpoints.ResetUserPassword)
+			account.POST("/deactivate", endpoints.DeactivateUser)
+			account.POST("/2fa/enroll", endpoints.EnrollTOTP)
+			account.POST("/2fa/verify", endpoints.VerifyTOTP)
+			account.POST("/2fa/disable", endpoints.DisableTOTP)
+			account.POST("/tokens", endpoints.ListAPITokens)
+			account.POST("/tokens/create", endpoints.CreateAPIToken)
+			account.POST("/tokens/revoke", endpoints.RevokeAPIToken)
+			account.POST("/export", endpoints.ExportData)
+			account.POST("/export/status", endpoints.ExportStatus)
+			account.GET("/export/:exportId", endpoints.DownloadExport)
+			account.POST("/profile", endpoints.GetProfile)
+			account.POST("/profile/update", endpoints.UpdateProfile)
+			account.POST("/profile/avatar", endpoints.UploadAvatar)
+		}
+	}
+
+	// Public profile endpoints
+	profiles := api.Group("/profiles")
+	{
+		profiles.GET("/:userId", endpoints.ViewProfile)
+		profiles.GET("/:userId/avatar", endpoints.GetAvatar)
+	}
+
+	// Moderation endpoints
+	admin := api.Group("/admin", middleware.JWT(), middleware.RequireRole(models.RoleModerator))
+	{
+		admin.POST("/users", endpoints.AdminSearchUsers)
+		admin.POST("/polls", endpoints.AdminSearchPolls)
+		admin.POST("/polls/close", endpoints.AdminClosePoll)
+		admin.POST("/polls/delete", endpoints.AdminDeletePoll)
+		admin.POST("/users/disable", middleware.RequireRole(models.RoleAdmin), endpoints.AdminDisableUser)
+		admin.POST("/users/role", middleware.RequireRole(models.RoleAdmin), endpoints.AdminSetRole)
 	}
 
 	r.Run(":8080")
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	tokensColl *mongo.Collection = database.Mongo.Database("test").Collection("tokens")
+)
+
+// Auth accepts either a browser session or a personal API token sent as
+// `Authorization: Bearer <token>`. API tokens must have been granted `scope`
+func Auth(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log.Printf("Private endpoint hit: %s\n", c.Request.URL)
+
+		if bearer := bearerToken(c); bearer != "" {
+			apiTokenAuth(c, bearer, scope)
+			return
+		}
+		sessionAuth(c)
+	}
+}
+
+// OptionalAuth is like Auth, but lets requests without credentials through anonymously.
+// An expired or invalid session cookie is ignored instead of rejected, while an invalid
+// API token is still rejected since the caller explicitly asked to be authenticated
+func OptionalAuth(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if bearer := bearerToken(c); bearer != "" {
+			apiTokenAuth(c, bearer, scope)
+			return
+		}
+
+		cookie, _ := c.Request.Cookie("accessToken")
+		if cookie == nil || cookie.Value == "" {
+			return
+		}
+		accessClaims, err := auth.ParseToken(cookie.Value, auth.TokenTypeAccess)
+		if err != nil {
+			return
+		}
+		if user, active := activeUser(accessClaims.Issuer); active {
+			accessClaims.Role = userRole(user)
+			c.Set("accessClaims", accessClaims)
+		}
+	}
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func apiTokenAuth(c *gin.Context, bearer string, scope string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !auth.IsAPIToken(bearer) {
+		responses.Send(c, http.StatusUnauthorized, "Invalid API token", gin.H{})
+		c.Abort()
+		return
+	}
+
+	var token models.APIToken
+	err := tokensColl.FindOne(ctx, bson.M{"hash": auth.HashAPIToken(bearer)}, options.FindOne()).Decode(&token)
+	if err != nil || token.Revoked || token.ExpiresAt.Before(time.Now()) {
+		responses.Send(c, http.StatusUnauthorized, "Invalid API token", gin.H{
+			"reason": "API token is unknown, revoked or expired",
+		})
+		c.Abort()
+		return
+	}
+
+	hasScope := false
+	for _, s := range token.Scopes {
+		if s == scope {
+			hasScope = true
+		}
+	}
+	if !hasScope {
+		responses.Send(c, http.StatusForbidden, "API token is missing a required scope", gin.H{
+			"scope": scope,
+		})
+		c.Abort()
+		return
+	}
+
+	owner, active := activeUser(token.Owner.Hex())
+	if !active {
+		responses.Send(c, http.StatusUnauthorized, "Invalid API token", gin.H{
+			"reason": "API token owner is disabled",
+		})
+		c.Abort()
+		return
+	}
+
+	_, err = tokensColl.UpdateOne(ctx, bson.M{"_id": token.Id}, bson.M{"$set": bson.M{"lastUsedAt": time.Now()}})
+	if err != nil {
+		log.Printf("Couldn't update lastUsedAt of API token %s: %s\n", token.TokenId, err.Error())
+	}
+
+	log.Printf("Authenticated API token %s of user %s\n", token.TokenId, token.Owner.Hex())
+	c.Set("accessClaims", &auth.Claims{
+		Role:           userRole(owner),
+		StandardClaims: jwt.StandardClaims{Issuer: token.Owner.Hex()},
+	})
+	c.Set("apiToken", token)
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Platforms that put the client's address somewhere other than a plain X-Forwarded-For
+const (
+	// PlatformHeroku's router appends the connecting address to X-Forwarded-For, but its
+	// own address isn't fixed, so the peer is always trusted as a single proxy hop
+	PlatformHeroku = "heroku"
+	// PlatformCloudflare sends the client's address in CF-Connecting-IP
+	PlatformCloudflare = "cloudflare"
+	// PlatformAppEngine sends the client's address in X-Appengine-Remote-Addr
+	PlatformAppEngine = "appengine"
+)
+
+var (
+	// TrustedProxies are the proxies whose X-Forwarded-For entries are believed, configured
+	// as comma separated IPs or CIDRs in `TRUSTED_PROXIES`. By default nothing is trusted
+	// and the client IP is the address of the connection
+	TrustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+	// TrustedPlatform is `TRUSTED_PLATFORM`, either one of the Platform* constants or the
+	// name of a header that holds the client's address
+	TrustedPlatform = strings.TrimSpace(os.Getenv("TRUSTED_PLATFORM"))
+
+	// IPv6 clients usually get a whole prefix, so their addresses are grouped by it
+	ipv6GroupPrefix = ipv6PrefixLength(os.Getenv("IPV6_DEDUPE_PREFIX"))
+)
+
+func parseTrustedProxies(value string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %s: %s\n", entry, err.Error())
+			continue
+		}
+		proxies = append(proxies, cidr)
+	}
+	return proxies
+}
+
+func ipv6PrefixLength(value string) int {
+	prefix, err := strconv.Atoi(value)
+	if err != nil || prefix < 0 || prefix > 128 {
+		return 64
+	}
+	return prefix
+}
+
+// TrustedProxyCIDRs are the TrustedProxies in the form gin.Engine.SetTrustedProxies takes
+func TrustedProxyCIDRs() []string {
+	cidrs := []string{}
+	for _, proxy := range TrustedProxies {
+		cidrs = append(cidrs, proxy.String())
+	}
+	return cidrs
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, proxy := range TrustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressGroup is the address used to tell clients apart: IPv4 addresses as they are and
+// IPv6 addresses as the prefix they belong to, e.g. 2001:db8:1:2::/64
+func AddressGroup(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return address
+	}
+	mask := net.CIDRMask(ipv6GroupPrefix, 128)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// ClientIP resolves the client's address from the configured trusted proxies and platform,
+// instead of believing whatever X-Forwarded-For says. It sets `clientIP`, `clientAddr` (its
+// AddressGroup) and `clientIPChain`, every address the request claims to have passed
+// through with the connecting peer last
+func ClientIP() gin.HandlerFunc {
+	if len(platformHeader()) > 0 && len(TrustedProxies) == 0 {
+		log.Printf("TRUSTED_PLATFORM %s is ignored until its proxies are listed in TRUSTED_PROXIES\n", TrustedPlatform)
+	}
+	return func(c *gin.Context) {
+		chain := forwardedChain(c.Request)
+		clientIP := resolveClientIP(c.Request, chain)
+
+		c.Set("clientIP", clientIP)
+		c.Set("clientAddr", AddressGroup(clientIP))
+		c.Set("clientIPChain", chain)
+		c.Next()
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			if entry = strings.TrimSpace(entry); len(entry) > 0 {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	return append(chain, remoteIP(r))
+}
+
+// platformHeader is the header the trusted platform puts the client's address in, if any
+func platformHeader() string {
+	switch TrustedPlatform {
+	case "", PlatformHeroku:
+		return ""
+	case PlatformCloudflare:
+		return "CF-Connecting-IP"
+	case PlatformAppEngine:
+		return "X-Appengine-Remote-Addr"
+	default:
+		return TrustedPlatform
+	}
+}
+
+func resolveClientIP(r *http.Request, chain []string) string {
+	peer := chain[len(chain)-1]
+
+	// Anyone can send the platform's header, so it only counts when the platform's own proxy
+	// connected to us
+	if header := platformHeader(); len(header) > 0 && isTrustedProxy(net.ParseIP(peer)) {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(header))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	// Walk back from the peer, believing each hop only while it was added by a trusted proxy
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			// Garbage in the header can't be trusted, and neither can anything before it
+			if i+1 < len(chain) {
+				return chain[i+1]
+			}
+			return peer
+		}
+		trusted := isTrustedProxy(ip) || (TrustedPlatform == PlatformHeroku && i == len(chain)-1)
+		if !trusted || i == 0 {
+			return ip.String()
+		}
+	}
+	return peer
+}
@@ -13,7 +13,7 @@ func CORS() gin.HandlerFunc {
 		AllowOrigins:     []string{"http://localhost:3000"}, //TODO: Change domain in production
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "OPTIONS"},
 		AllowHeaders:     []string{
-			"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization",
+			"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization", "X-Poll-Grant",
 		}, 
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
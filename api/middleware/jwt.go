@@ -1,40 +1,99 @@
 package middleware
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
+
 	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/models"
 	"rapidvote/api/responses"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	usersColl *mongo.Collection = database.Mongo.Database("test").Collection("users")
 )
 
+// JWT only lets through requests with a valid browser session. Personal API tokens
+// aren't accepted here, so they can't be used to manage the account itself
 func JWT() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		log.Printf("Private endpoint hit: %s\n", c.Request.URL)
+		sessionAuth(c)
+	}
+}
 
-		cookie, _ := c.Request.Cookie("accessToken")
-		if cookie == nil || cookie.Value == "" {
-			responses.Send(c, http.StatusUnauthorized, "User is not authorized", gin.H{
-				"reason": "accessToken cookie nil or undefined",
-			})
-			c.Abort()
-			return
-		} 
-
-		accessToken := cookie.Value
-		accessClaims, err := auth.ParseToken(accessToken, auth.TokenTypeAccess)
-		if err != nil {
-			log.Printf("Error: %+v\n", err)
-			reauthRequired := true
-			responses.Send(c, http.StatusInternalServerError, "Couldn't parse accessToken", gin.H{
-				"reason": err.Error(),
-				"reauthRequired": reauthRequired,
-			})
-			c.Abort()
-			return
-		}
+func sessionAuth(c *gin.Context) {
+	cookie, _ := c.Request.Cookie("accessToken")
+	if cookie == nil || cookie.Value == "" {
+		responses.Send(c, http.StatusUnauthorized, "User is not authorized", gin.H{
+			"reason": "accessToken cookie nil or undefined",
+		})
+		c.Abort()
+		return
+	} 
+
+	accessToken := cookie.Value
+	accessClaims, err := auth.ParseToken(accessToken, auth.TokenTypeAccess)
+	if err != nil {
+		log.Printf("Error: %+v\n", err)
+		reauthRequired := true
+		responses.Send(c, http.StatusInternalServerError, "Couldn't parse accessToken", gin.H{
+			"reason": err.Error(),
+			"reauthRequired": reauthRequired,
+		})
+		c.Abort()
+		return
+	}
+
+	user, active := activeUser(accessClaims.Issuer)
+	if !active {
+		responses.Send(c, http.StatusForbidden, "Account has been disabled", gin.H{
+			"reauthRequired": true,
+		})
+		c.Abort()
+		return
+	}
 
-		c.Set("accessClaims", accessClaims)
+	accessClaims.Role = userRole(user)
+	c.Set("accessClaims", accessClaims)
+}
+
+// userRole is the role stored on the user. Handlers read the role from the claims, and it
+// replaces the one the token was issued with, so demoting a user is as immediate as disabling them
+func userRole(user models.User) string {
+	if user.Role == "" {
+		return models.RoleUser
+	}
+	return user.Role
+}
+
+// activeUser looks up the user, and reports whether they still exist and aren't disabled or deactivated.
+// Tokens stay valid until they expire, so this is what makes disabling an account immediate
+func activeUser(userId string) (models.User, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return models.User{}, false
+	}
+
+	var user models.User
+	err = usersColl.FindOne(ctx, bson.M{"_id": id}, options.FindOne()).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Couldn't look up user %s: %s\n", userId, err.Error())
+		}
+		return models.User{}, false
 	}
+	return user, !user.Disabled && user.DeactivatedAt == nil
 }
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/database"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limit is a token bucket holding up to `Requests` tokens, refilled evenly over `Per`.
+// The zero Limit doesn't limit anything
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// rate is how many tokens are added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitConfig limits one route, or group of routes sharing a `Route` name
+type RateLimitConfig struct {
+	Route string
+	// PerIP applies to every client address, grouped by prefix for IPv6
+	PerIP Limit
+	// PerUser applies to every logged in user, whether through a session or an API token
+	PerUser Limit
+	// PerRoute applies to everyone together
+	PerRoute Limit
+}
+
+// RateLimitStore keeps the token buckets. Take removes a token from the bucket at `key`,
+// or reports how long until one is available
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// RateLimits is chosen by `RATE_LIMIT_BACKEND`: `memory` (the default) keeps buckets in this
+// process, `mongo` shares them between every instance of the API
+var RateLimits RateLimitStore = newRateLimitStore(os.Getenv("RATE_LIMIT_BACKEND"))
+
+func newRateLimitStore(backend string) RateLimitStore {
+	switch backend {
+	case "", "memory":
+		return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+	case "mongo":
+		return &mongoRateLimitStore{coll: database.Mongo.Database("test").Collection("rate_limits")}
+	default:
+		log.Printf("Unknown RATE_LIMIT_BACKEND %s, using memory\n", backend)
+		return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+	}
+}
+
+// RateLimit rejects requests over any of the configured limits with a 429. It has to run
+// after Auth or OptionalAuth for per-user limits to apply
+func RateLimit(config RateLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		type bucket struct {
+			key   string
+			limit Limit
+		}
+		var buckets []bucket
+		if config.PerIP.enabled() {
+			addr := c.GetString("clientAddr")
+			if len(addr) == 0 {
+				addr = c.ClientIP()
+			}
+			buckets = append(buckets, bucket{config.Route + ":ip:" + addr, config.PerIP})
+		}
+		if config.PerUser.enabled() {
+			if accessClaims, exists := c.Get("accessClaims"); exists {
+				userId := accessClaims.(*auth.Claims).Issuer
+				buckets = append(buckets, bucket{config.Route + ":user:" + userId, config.PerUser})
+			}
+		}
+		if config.PerRoute.enabled() {
+			buckets = append(buckets, bucket{config.Route + ":all", config.PerRoute})
+		}
+
+		// Stopping at the first rejection keeps a client over its own limit from draining the
+		// buckets it shares with others, like the route's
+		var retryAfter time.Duration
+		for _, b := range buckets {
+			allowed, wait, err := RateLimits.Take(ctx, b.key, b.limit)
+			if err != nil {
+				// Better to let requests through than to take the API down with the store
+				log.Printf("Couldn't check rate limit %s: %s\n", b.key, err.Error())
+				continue
+			}
+			if !allowed {
+				retryAfter = wait
+				break
+			}
+		}
+		if retryAfter == 0 {
+			return
+		}
+
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Header("Retry-After", fmt.Sprint(seconds))
+		responses.Send(c, http.StatusTooManyRequests, "Too many requests", gin.H{
+			"retryAfter": seconds,
+		})
+		c.Abort()
+	}
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// How long the bucket takes to refill completely
+	refill time.Duration
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	capacity := float64(limit.Requests)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updatedAt: now, refill: limit.Per}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updatedAt).Seconds()*limit.rate())
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / limit.rate() * float64(time.Second)), nil
+}
+
+// sweep forgets buckets that have refilled completely, since they are as good as new ones
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updatedAt) > b.refill {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+type mongoRateLimitStore struct {
+	coll      *mongo.Collection
+	indexOnce sync.Once
+}
+
+type mongoTokenBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+// Take refills and takes from the bucket in a single pipeline update, so concurrent
+// requests on any instance can't take the same token twice
+func (s *mongoRateLimitStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.indexOnce.Do(func() {
+		// Buckets are deleted once they would have refilled completely
+		_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			log.Printf("Couldn't create rate limit expiry index: %s\n", err.Error())
+		}
+	})
+
+	capacity := float64(limit.Requests)
+	ratePerMs := limit.rate() / 1000
+	refilled := bson.M{"$min": bson.A{
+		capacity,
+		bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+			bson.M{"$multiply": bson.A{
+				bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updatedAt", "$$NOW"}}}},
+				ratePerMs,
+			}},
+		}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updatedAt": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$tokens", 1}},
+				bson.M{"$subtract": bson.A{"$tokens", 1}},
+				"$tokens",
+			}},
+			"expiresAt": bson.M{"$add": bson.A{"$$NOW", limit.Per.Milliseconds()}},
+		}}},
+	}
+
+	var bucket mongoTokenBucket
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&bucket)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if bucket.Allowed {
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - bucket.Tokens) / limit.rate() * float64(time.Second)), nil
+}
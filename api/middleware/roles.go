@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"rapidvote/api/auth"
+	"rapidvote/api/models"
+	"rapidvote/api/responses"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets through users whose role grants at least `role`. It has to run after
+// JWT or Auth, which put the role currently stored on the user in the claims
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessClaims, exists := c.Get("accessClaims")
+		if !exists {
+			responses.Send(c, http.StatusUnauthorized, "Couldn't get accessClaims", gin.H{})
+			c.Abort()
+			return
+		}
+
+		claims := accessClaims.(*auth.Claims)
+		if !models.HasRole(claims.Role, role) {
+			responses.Send(c, http.StatusForbidden, "User doesn't have the required role", gin.H{
+				"role": role,
+			})
+			c.Abort()
+			return
+		}
+	}
+}
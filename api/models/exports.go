@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Status of a personal data export
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Export is a personal data export that is too large to build during the request.
+// The archive is written to local disk and can be downloaded until it expires
+type Export struct {
+	Owner       primitive.ObjectID `bson:"owner"`
+	Status      string             `bson:"status"`
+	Error       string             `bson:"error,omitempty"`
+	Path        string             `bson:"path,omitempty" json:"-"`
+	Size        int64              `bson:"size,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	CompletedAt *time.Time         `bson:"completedAt,omitempty"`
+	ExpiresAt   time.Time          `bson:"expiresAt"`
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+}
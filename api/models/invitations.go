@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation lets one voter vote on an invitation-only poll. It deliberately doesn't record
+// when it was used or which vote it was used for, so the creator can see who voted but not
+// how they voted
+type Invitation struct {
+	PollId    string             `bson:"pollId"`
+	Email     string             `bson:"email"`
+	Name      string             `bson:"name"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	Used      bool               `bson:"used"`
+	SentAt    *time.Time         `bson:"sentAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+}
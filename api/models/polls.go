@@ -3,26 +3,248 @@ package models
 import (
 	"time"
 
+	"rapidvote/api/blindsig"
+	"rapidvote/api/elgamal"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type Vote struct {
-	PollId    string			 `bson:"pollId"`
-	Choice    uint				 `bson:"choice"`
-	VoterId   primitive.ObjectID `bson:"voterId"`
-	VoterAddr string			 `bson:"voterAddr"`
-	Id        primitive.ObjectID `bson:"_id,omitempty"`
+// How a poll tells whether someone already voted
+const (
+	// DedupeAccount only lets logged in users vote, once per account
+	DedupeAccount = "account"
+	// DedupeDevice allows one vote per account, and one per signed voter token for anonymous voters
+	DedupeDevice = "device"
+	// DedupeIP allows one vote per account, and one per IP address for anonymous voters
+	DedupeIP = "ip"
+	// DedupeNone allows any number of votes
+	DedupeNone = "none"
+)
+
+func IsValidDedupePolicy(policy string) bool {
+	switch policy {
+	case DedupeAccount, DedupeDevice, DedupeIP, DedupeNone:
+		return true
+	}
+	return false
+}
+
+// Who can see a poll's results. Its creator and moderators always can
+const (
+	ResultsAlways     = "always"
+	ResultsAfterVote  = "after_vote"
+	ResultsAfterClose = "after_close"
+	ResultsCreator    = "creator"
+)
+
+func IsValidResultsVisibility(visibility string) bool {
+	switch visibility {
+	case ResultsAlways, ResultsAfterVote, ResultsAfterClose, ResultsCreator:
+		return true
+	}
+	return false
+}
+
+// Whether a poll's voters can be seen next to their choices
+const (
+	// BallotPublic polls list who voted for each option
+	BallotPublic = "public"
+	// BallotSecret polls never reveal who voted for what
+	BallotSecret = "secret"
+)
+
+func IsValidBallotSecrecy(secrecy string) bool {
+	return secrecy == BallotPublic || secrecy == BallotSecret
+}
+
+// Review states of a participation. Participations without a status were never flagged,
+// and count like accepted ones
+const (
+	VoteQuarantined = "quarantined"
+	VoteAccepted    = "accepted"
+	VoteRejected    = "rejected"
+)
+
+// Why the anomaly detector quarantined a vote
+const (
+	FlagSubnetBurst = "subnet_burst"
+	FlagUserAgent   = "identical_user_agent"
+	FlagRateSpike   = "rate_spike"
+)
+
+// Participation records that someone voted on a poll, for dedupe and anomaly detection.
+// It says nothing about what they voted for, that's in a Ballot
+type Participation struct {
+	PollId  string             `bson:"pollId"`
+	VoterId primitive.ObjectID `bson:"voterId"`
+	// VoterAddr is the voter's address, grouped by prefix for IPv6
+	VoterAddr string `bson:"voterAddr"`
+	// VoterIPChain is every address the vote passed through, with the connecting peer last
+	VoterIPChain []string `bson:"voterIPChain,omitempty"`
+	VoterDevice  string   `bson:"voterDevice,omitempty"`
+	// VoterSubnet is the /24 (IPv4) or /48 (IPv6) the voter's address belongs to
+	VoterSubnet string    `bson:"voterSubnet,omitempty"`
+	UserAgent   string    `bson:"userAgent,omitempty"`
+	CastAt      time.Time `bson:"castAt"`
+	Status      string    `bson:"status,omitempty"`
+	Flags       []string  `bson:"flags,omitempty"`
+	// DedupeKeys are what the poll's dedupe policy tells this voter apart by. They are
+	// unique, so of two votes cast at once by the same voter only one is recorded
+	DedupeKeys []string `bson:"dedupeKeys,omitempty" json:"-"`
+	// HeldBallot refers to a quarantined vote's held ballot until it is reviewed, as a keyed
+	// hash of the ballot's ID
+	HeldBallot string             `bson:"heldBallot,omitempty" json:"-"`
+	ReviewedBy primitive.ObjectID `bson:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time         `bson:"reviewedAt,omitempty"`
+	Id         primitive.ObjectID `bson:"_id,omitempty"`
+}
+
+// Ballot is a counted vote. Its ID is random and its time is rounded down to a bucket, so
+// it can't be matched with the Participation written alongside it
+type Ballot struct {
+	BallotId string `bson:"_id"`
+	PollId   string `bson:"pollId"`
+	Choice   uint   `bson:"choice"`
+	// Encrypted replaces Choice on polls with encrypted ballots
+	Encrypted *elgamal.Ballot `bson:"encrypted,omitempty"`
+	// VoterId is only kept for polls with public ballots
+	VoterId primitive.ObjectID `bson:"voterId,omitempty"`
+	CastAt  time.Time          `bson:"castAt"`
+	// Held ballots belong to quarantined votes. They don't count and aren't logged until
+	// the vote is accepted, and are deleted if it is rejected
+	Held bool `bson:"held,omitempty"`
+	// Seq and ChainHash are set once the ballot is appended to the poll's ballot log
+	Seq       int64  `bson:"seq,omitempty"`
+	ChainHash string `bson:"chainHash,omitempty"`
+}
+
+// EncryptedTally is the sum of an encrypted poll's ballots, frozen when the poll closes, and
+// the trustees' partial decryptions of it
+type EncryptedTally struct {
+	PollId    string                      `bson:"_id"`
+	Aggregate []elgamal.Ciphertext        `bson:"aggregate"`
+	Ballots   int64                       `bson:"ballots"`
+	Partials  []elgamal.PartialDecryption `bson:"partials"`
+	// Result is set once Threshold trustees have decrypted
+	Result      *elgamal.Tally `bson:"result,omitempty"`
+	DecryptedAt *time.Time     `bson:"decryptedAt,omitempty"`
+}
+
+// BallotLog is the head of a poll's hash-chained ballot log
+type BallotLog struct {
+	PollId string `bson:"_id"`
+	// Size is how many ballots were appended, Head the chain hash after the last of them
+	Size int64  `bson:"size"`
+	Head string `bson:"head"`
+	// MerkleRoot is published when the poll closes, covering the first MerkleSize ballots.
+	// Ballots accepted from quarantine after that extend the log and update the root
+	MerkleRoot string     `bson:"merkleRoot,omitempty"`
+	MerkleSize int64      `bson:"merkleSize,omitempty"`
+	SealedAt   *time.Time `bson:"sealedAt,omitempty"`
+}
+
+// CredentialKey is the private key a poll's anonymous credentials are signed with
+type CredentialKey struct {
+	PollId string `bson:"_id"`
+	// PrivateKey is PKCS #1 DER
+	PrivateKey []byte    `bson:"privateKey"`
+	CreatedAt  time.Time `bson:"createdAt"`
+}
+
+// CredentialIssuance records that a user got their credential for a poll. The blinded
+// message is kept so the same one can be signed again if the response got lost, it says
+// nothing about the credential it turns into
+type CredentialIssuance struct {
+	Id       string             `bson:"_id"`
+	PollId   string             `bson:"pollId"`
+	UserId   primitive.ObjectID `bson:"userId"`
+	Blinded  string             `bson:"blinded"`
+	IssuedAt time.Time          `bson:"issuedAt"`
+}
+
+// Privacy is a poll's differential privacy budget. Epsilon (and Delta, for the Gaussian
+// mechanism) is split evenly between up to Releases releases of noisy results, the last of
+// which is kept for when the poll closes
+type Privacy struct {
+	Mechanism string  `bson:"mechanism"`
+	Epsilon   float64 `bson:"epsilon"`
+	Delta     float64 `bson:"delta,omitempty"`
+	Releases  int     `bson:"releases"`
+}
+
+// NoisyRelease is one release of a poll's results with differential privacy noise
+type NoisyRelease struct {
+	Counts  []int64 `bson:"counts"`
+	Epsilon float64 `bson:"epsilon"`
+	Delta   float64 `bson:"delta,omitempty"`
+	// Final releases are drawn after the poll closed, and there is only ever one
+	Final      bool      `bson:"final"`
+	ReleasedAt time.Time `bson:"releasedAt"`
+}
+
+// NoisyResults are every release of a poll's noisy results. They are served again and again
+// until the next one, so asking for the results repeatedly doesn't spend more of the budget
+type NoisyResults struct {
+	PollId   string         `bson:"_id"`
+	Count    int            `bson:"count"`
+	Releases []NoisyRelease `bson:"releases"`
+}
+
+// Eligibility restricts who can vote on a poll. Any rule means voters have to log in
+type Eligibility struct {
+	// AllowedDomains are the email domains voters' accounts must belong to
+	AllowedDomains []string `bson:"allowedDomains,omitempty"`
+	// AllowedVoters are the user IDs or emails of everyone who may vote. Hidden, so the
+	// electorate's emails aren't shown to everyone viewing the poll
+	AllowedVoters     []string `bson:"allowedVoters,omitempty" json:"-"`
+	MinAccountAgeDays int      `bson:"minAccountAgeDays,omitempty"`
+}
+
+func (e Eligibility) IsEmpty() bool {
+	return len(e.AllowedDomains) == 0 && len(e.AllowedVoters) == 0 && e.MinAccountAgeDays <= 0
 }
 
 // TODO: Add field validation for all models
 type Poll struct {
-	Name         string             `bson:"name"`
-	Description  string             `bson:"description"`
-	Options      []string           `bson:"options"`
-	Expiration   time.Time          `bson:"expiration"`
-	Status       bool               `bson:"status"`
-	AuthRequired bool               `bson:"authRequired"`
-	PollId       string             `bson:"pollId"`
-	Creator      primitive.ObjectID `bson:"creator"`
-	Id           primitive.ObjectID `bson:"_id,omitempty"`
+	Name        string    `bson:"name"`
+	Description string    `bson:"description"`
+	Options     []string  `bson:"options"`
+	Expiration  time.Time `bson:"expiration"`
+	Status      bool      `bson:"status"`
+	// ClosedAt is when the poll was closed. Polls that expired, or were closed before it
+	// existed, have none
+	ClosedAt     *time.Time   `bson:"closedAt,omitempty"`
+	AuthRequired bool         `bson:"authRequired"`
+	Eligibility  *Eligibility `bson:"eligibility,omitempty"`
+	// Public polls are listed on their creator's profile
+	Public bool `bson:"public"`
+	// InviteOnly polls can only be voted on with an Invitation
+	InviteOnly bool `bson:"inviteOnly"`
+	// Protected polls can only be seen and voted on with the passphrase
+	Protected      bool   `bson:"protected"`
+	PassphraseHash string `bson:"passphraseHash,omitempty" json:"-"`
+	// DedupePolicy is one of the Dedupe* constants. Polls created before it existed have none
+	// set, and are deduplicated by IP
+	DedupePolicy string `bson:"dedupePolicy"`
+	// BallotSecrecy is BallotPublic or BallotSecret. Polls created before it existed have none
+	// set, and are secret
+	BallotSecrecy string `bson:"ballotSecrecy,omitempty"`
+	// ResultsVisibility is one of the Results* constants. Polls created before it existed
+	// have none set, and always show their results
+	ResultsVisibility string `bson:"resultsVisibility,omitempty"`
+	// Election is the trustees' key ballots are encrypted with. Polls without one have plain
+	// ballots
+	Election *elgamal.ElectionKey `bson:"election,omitempty"`
+	// Credentials is the key of polls voted on with anonymous credentials, which logged in
+	// users get blindly signed and then vote with without logging in
+	Credentials *blindsig.PublicKey `bson:"credentials,omitempty"`
+	// Privacy polls only publish results with differential privacy noise. Their creator
+	// sees the exact counts once the poll is closed
+	Privacy *Privacy `bson:"privacy,omitempty"`
+	// Challenge is the kind of challenge anonymous voters must solve, see package challenge.
+	// Empty when there is none
+	Challenge string             `bson:"challenge,omitempty"`
+	PollId    string             `bson:"pollId"`
+	Creator   primitive.ObjectID `bson:"creator"`
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIToken is a personal access token used for scripted access. Only the hash of
+// the token is stored, the token itself is shown once when it is created
+type APIToken struct {
+	Name       string             `bson:"name"`
+	TokenId    string             `bson:"tokenId"`
+	Hash       string             `bson:"hash" json:"-"`
+	Scopes     []string           `bson:"scopes"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	LastUsedAt time.Time          `bson:"lastUsedAt,omitempty"`
+	Revoked    bool               `bson:"revoked"`
+	Owner      primitive.ObjectID `bson:"owner"`
+	Id         primitive.ObjectID `bson:"_id,omitempty"`
+}
@@ -1,9 +1,102 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Identity links a User to their account at an external OpenID Connect provider
+type Identity struct {
+	Provider string `bson:"provider"`
+	Subject  string `bson:"subject"`
+}
+
+// Roles a user can have. Every role includes the permissions of the ones before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether `role` grants at least the permissions of `required`.
+// Users created before roles existed have no role, and count as RoleUser
+func HasRole(role string, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// What happens to a deactivated user's polls when the account is purged
+const (
+	PollsDelete    = "delete"
+	PollsTransfer  = "transfer"
+	PollsAnonymize = "anonymize"
+)
+
+// DeactivationChoices is what the user chose to do with their data when they deactivated
+type DeactivationChoices struct {
+	Polls      string             `bson:"polls"`
+	TransferTo primitive.ObjectID `bson:"transferTo,omitempty"`
+	// KeepVotes keeps the user's votes as anonymous ballots instead of deleting them
+	KeepVotes  bool               `bson:"keepVotes"`
+}
 
 type User struct {
 	Email      string             `json:"email"`
-	Password   string             `json:"password"`
+	Password   string             `json:"-"`
+	// EmailVerified is set once an identity provider vouches for Email. Registering or
+	// changing the email never verifies it
+	EmailVerified bool `bson:"emailVerified,omitempty"`
+	Identities []Identity         `bson:"identities,omitempty"`
+	Role       string             `bson:"role,omitempty"`
+
+	// Public profile. Avatar is the file name of the resized avatar in the avatar directory
+	DisplayName string `bson:"displayName,omitempty"`
+	Bio         string `bson:"bio,omitempty"`
+	Avatar      string `bson:"avatar,omitempty"`
+
+	// Disabled accounts can't log in or use their existing sessions and API tokens
+	Disabled   bool               `bson:"disabled"`
+
+	// Accounts deactivated by their owner can be reactivated until PurgeAt,
+	// after which the purge job deletes them as described by Deactivation
+	DeactivatedAt *time.Time            `bson:"deactivatedAt,omitempty"`
+	PurgeAt       *time.Time            `bson:"purgeAt,omitempty"`
+	Deactivation  *DeactivationChoices  `bson:"deactivation,omitempty"`
+
+	// TOTP two-factor authentication. The secret is stored as soon as enrollment starts,
+	// but only enforced once the first code has been verified and TOTPEnabled is set
+	TOTPSecret      string   `bson:"totpSecret,omitempty" json:"-"`
+	TOTPEnabled     bool     `bson:"totpEnabled"`
+	TOTPLastCounter int64    `bson:"totpLastCounter,omitempty"`
+	RecoveryCodes   []string `bson:"recoveryCodes,omitempty" json:"-"`
+
 	Id         primitive.ObjectID `bson:"_id,omitempty"`
 }
+
+// LoginAttempts tracks failed logins for a single key, either an account's email or a client IP
+type LoginAttempts struct {
+	Key         string             `bson:"key"`
+	Failures    int                `bson:"failures"`
+	LastFailure time.Time          `bson:"lastFailure"`
+	LockedUntil time.Time          `bson:"lockedUntil"`
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+}
+
+// PublicProfile is what anyone can see about a user, e.g. as the creator of a shared poll
+type PublicProfile struct {
+	UserId      primitive.ObjectID `json:"userId"`
+	DisplayName string             `json:"displayName"`
+	Bio         string             `json:"bio"`
+	AvatarUrl   string             `json:"avatarUrl,omitempty"`
+}
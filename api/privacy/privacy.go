@@ -0,0 +1,114 @@
+// Package privacy adds differential privacy noise to poll results. A vote changes a single
+// option's count by one, so every count gets noise calibrated to a sensitivity of 1, from the
+// Laplace or the Gaussian mechanism. Noise comes from crypto/rand, and noisy counts are
+// rounded and never negative, which is post-processing and costs no privacy
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Mechanisms a poll can use
+const (
+	// Laplace gives pure epsilon-differential privacy
+	Laplace = "laplace"
+	// Gaussian gives (epsilon, delta)-differential privacy, with noise that has lighter tails
+	Gaussian = "gaussian"
+)
+
+// DefaultDelta is used by Gaussian polls that don't set one
+const DefaultDelta = 1e-6
+
+var (
+	ErrMechanism = errors.New("unknown differential privacy mechanism")
+	ErrEpsilon   = errors.New("epsilon must be positive, and at most 1 for the Gaussian mechanism")
+	ErrDelta     = errors.New("delta must be between 0 and 1")
+)
+
+func IsValidMechanism(mechanism string) bool {
+	return mechanism == Laplace || mechanism == Gaussian
+}
+
+// Validate checks the parameters of one release. The Gaussian mechanism's calibration only
+// holds for epsilon up to 1
+func Validate(mechanism string, epsilon float64, delta float64) error {
+	if !IsValidMechanism(mechanism) {
+		return ErrMechanism
+	}
+	if !(epsilon > 0) || math.IsInf(epsilon, 1) || (mechanism == Gaussian && epsilon > 1) {
+		return ErrEpsilon
+	}
+	if mechanism == Gaussian && !(delta > 0 && delta < 1) {
+		return ErrDelta
+	}
+	return nil
+}
+
+// Scale is the Laplace mechanism's b, or the Gaussian mechanism's standard deviation
+func Scale(mechanism string, epsilon float64, delta float64) float64 {
+	if mechanism == Gaussian {
+		return math.Sqrt(2*math.Log(1.25/delta)) / epsilon
+	}
+	return 1 / epsilon
+}
+
+// uniform is a random float in [0, 1)
+func uniform() (float64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53), nil
+}
+
+func laplace(scale float64) (float64, error) {
+	u, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+	u -= 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u), nil
+	}
+	return -scale * math.Log(1-2*u), nil
+}
+
+// gaussian draws with the Box-Muller transform
+func gaussian(sigma float64) (float64, error) {
+	u1, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+	u2, err := uniform()
+	if err != nil {
+		return 0, err
+	}
+	return sigma * math.Sqrt(-2*math.Log(1-u1)) * math.Cos(2*math.Pi*u2), nil
+}
+
+// Noisy adds noise to every count, spending epsilon (and delta) once for all of them
+func Noisy(mechanism string, epsilon float64, delta float64, counts []int64) ([]int64, error) {
+	if err := Validate(mechanism, epsilon, delta); err != nil {
+		return nil, err
+	}
+	scale := Scale(mechanism, epsilon, delta)
+
+	noisy := make([]int64, len(counts))
+	for i, count := range counts {
+		var noise float64
+		var err error
+		if mechanism == Gaussian {
+			noise, err = gaussian(scale)
+		} else {
+			noise, err = laplace(scale)
+		}
+		if err != nil {
+			return nil, err
+		}
+		noisy[i] = int64(math.Max(0, math.Round(float64(count)+noise)))
+	}
+	return noisy, nil
+}
@@ -0,0 +1,116 @@
+package privacy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		epsilon   float64
+		delta     float64
+		want      error
+	}{
+		{Laplace, 1, 0, nil},
+		{Laplace, 10, 0, nil},
+		{Gaussian, 0.5, 1e-6, nil},
+		{"exponential", 1, 0, ErrMechanism},
+		{Laplace, 0, 0, ErrEpsilon},
+		{Laplace, -1, 0, ErrEpsilon},
+		{Laplace, math.Inf(1), 0, ErrEpsilon},
+		{Laplace, math.NaN(), 0, ErrEpsilon},
+		// The Gaussian mechanism's calibration only holds up to 1
+		{Gaussian, 2, 1e-6, ErrEpsilon},
+		{Gaussian, 1, 0, ErrDelta},
+		{Gaussian, 1, 1, ErrDelta},
+		{Gaussian, 1, math.NaN(), ErrDelta},
+	}
+	for _, test := range tests {
+		if err := Validate(test.mechanism, test.epsilon, test.delta); err != test.want {
+			t.Errorf("Validate(%s, %v, %v) = %v, want %v", test.mechanism, test.epsilon, test.delta, err, test.want)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	if scale := Scale(Laplace, 0.5, 0); scale != 2 {
+		t.Errorf("Laplace scale for epsilon 0.5 = %v, want 2", scale)
+	}
+	// sqrt(2 ln(1.25 / 1e-5)) / 0.5
+	if scale := Scale(Gaussian, 0.5, 1e-5); math.Abs(scale-9.6896) > 1e-3 {
+		t.Errorf("Gaussian scale for epsilon 0.5 and delta 1e-5 = %v, want 9.6896", scale)
+	}
+}
+
+// TestNoisyScale checks the noise drawn has the spread it is calibrated to: a mean absolute
+// deviation of b for Laplace noise, and a standard deviation of sigma for Gaussian noise
+func TestNoisyScale(t *testing.T) {
+	const draws = 20000
+	const count = 100000
+
+	tests := []struct {
+		mechanism string
+		epsilon   float64
+		delta     float64
+		spread    func(noise []float64) float64
+	}{
+		{Laplace, 0.1, 0, func(noise []float64) float64 {
+			total := 0.0
+			for _, n := range noise {
+				total += math.Abs(n)
+			}
+			return total / float64(len(noise))
+		}},
+		{Gaussian, 0.5, 1e-6, func(noise []float64) float64 {
+			total := 0.0
+			for _, n := range noise {
+				total += n * n
+			}
+			return math.Sqrt(total / float64(len(noise)))
+		}},
+	}
+	for _, test := range tests {
+		counts := make([]int64, draws)
+		for i := range counts {
+			counts[i] = count
+		}
+		noisy, err := Noisy(test.mechanism, test.epsilon, test.delta, counts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		noise := make([]float64, draws)
+		mean := 0.0
+		for i, n := range noisy {
+			noise[i] = float64(n - count)
+			mean += noise[i] / draws
+		}
+		want := Scale(test.mechanism, test.epsilon, test.delta)
+		if math.Abs(mean) > want/10 {
+			t.Errorf("%s: noise is biased, mean %v", test.mechanism, mean)
+		}
+		// Rounding to whole votes adds a little spread of its own
+		if spread := test.spread(noise); math.Abs(spread-want) > want/20 {
+			t.Errorf("%s: noise spread = %v, want %v", test.mechanism, spread, want)
+		}
+	}
+}
+
+func TestNoisyNeverNegative(t *testing.T) {
+	noisy, err := Noisy(Laplace, 0.01, 0, make([]int64, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range noisy {
+		if n < 0 {
+			t.Fatalf("noisy count %d is negative", n)
+		}
+	}
+}
+
+func TestNoisyChecksParameters(t *testing.T) {
+	if _, err := Noisy(Gaussian, 1, 0, []int64{1}); err != ErrDelta {
+		t.Errorf("Noisy without delta = %v, want %v", err, ErrDelta)
+	}
+}
@@ -0,0 +1,29 @@
+package requests
+
+type AdminSearchUsers struct {
+	Query    string `json:"query"`
+	Page     int64  `json:"page"`
+	PageSize int64  `json:"pageSize"`
+}
+
+type AdminSearchPolls struct {
+	Query    string `json:"query"`
+	Creator  string `json:"creator"`
+	Status   *bool  `json:"status"`
+	Page     int64  `json:"page"`
+	PageSize int64  `json:"pageSize"`
+}
+
+type AdminPoll struct {
+	PollId string `json:"pollId"`
+}
+
+type AdminDisableUser struct {
+	UserId   string `json:"userId"`
+	Disabled bool   `json:"disabled"`
+}
+
+type AdminSetRole struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
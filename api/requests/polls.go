@@ -2,30 +2,145 @@ package requests
 
 import (
 	"time"
+
+	"rapidvote/api/certificate"
+	"rapidvote/api/elgamal"
 )
 
+type Eligibility struct {
+	AllowedDomains    []string `json:"allowedDomains"`
+	AllowedVoters     []string `json:"allowedVoters"`
+	MinAccountAgeDays int      `json:"minAccountAgeDays"`
+}
+
 type CreatePoll struct {
-	Name         string       `json:"name"`
-	Description  string       `json:"description"`
-	Options      []string     `json:"options"`
-	Expiration   time.Time    `json:"expiration"`
-	Status       bool         `json:"status"`
-	AuthRequired bool         `json:"authRequired"`
-	PollId       string       `json:"pollId"`
-	Creator      string       `json:"creator"`
+	Name              string      `json:"name"`
+	Description       string      `json:"description"`
+	Options           []string    `json:"options"`
+	Expiration        time.Time   `json:"expiration"`
+	Status            bool        `json:"status"`
+	AuthRequired      bool        `json:"authRequired"`
+	Eligibility       Eligibility `json:"eligibility"`
+	Public            bool        `json:"public"`
+	InviteOnly        bool        `json:"inviteOnly"`
+	Passphrase        string      `json:"passphrase"`
+	DedupePolicy      string      `json:"dedupePolicy"`
+	Challenge         string      `json:"challenge"`
+	ResultsVisibility string      `json:"resultsVisibility"`
+	BallotSecrecy     string      `json:"ballotSecrecy"`
+	// Election is the trustees' key from `trustee keygen`, for polls with encrypted ballots
+	Election *elgamal.ElectionKey `json:"election"`
+	// AnonymousCredentials polls are voted on with blindly signed credentials instead of
+	// logging in, see IssueCredential
+	AnonymousCredentials bool `json:"anonymousCredentials"`
+	// Privacy adds differential privacy noise to the published results
+	Privacy *Privacy `json:"privacy"`
+	PollId  string   `json:"pollId"`
+	Creator string   `json:"creator"`
+}
+
+// Privacy is the differential privacy budget of a poll. Releases defaults to 1, which means
+// the results are only released once the poll closes
+type Privacy struct {
+	Mechanism string  `json:"mechanism"`
+	Epsilon   float64 `json:"epsilon"`
+	Delta     float64 `json:"delta"`
+	Releases  int     `json:"releases"`
 }
 
+// ViewPoll and VotePoll take the voter from their session, never from the request
 type ViewPoll struct {
-	UserId string `json:"userId"`
+	Invitation string `json:"invitation"`
+	// The access grant from UnlockPoll, for polls protected by a passphrase
+	Grant string `json:"grant"`
 }
 
 type VotePoll struct {
-	PollId    string 	`json:"pollId"`
-	Choice    uint		`json:"choice"`
-	UserId    string    `json:"userId"`
+	PollId string `json:"pollId"`
+	Choice uint   `json:"choice"`
+	// The challenge token from ViewPoll and its solution, for polls that ask anonymous voters for one
+	Challenge string `json:"challenge"`
+	Solution  string `json:"solution"`
+	// The invitation token, for invitation-only polls
+	Invitation string `json:"invitation"`
+	Grant      string `json:"grant"`
+	// EncryptedBallot replaces Choice on polls with encrypted ballots
+	EncryptedBallot *elgamal.Ballot `json:"encryptedBallot"`
+	// Credential replaces logging in on polls with anonymous credentials
+	Credential *Credential `json:"credential"`
+}
+
+// Credential is an unblinded credential: the voter's nonce and the poll's signature on it
+type Credential struct {
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+type IssueCredential struct {
+	PollId  string `json:"pollId"`
+	Blinded string `json:"blinded"`
+	Grant   string `json:"grant"`
 }
 
 type ClosePoll struct {
-	PollId    string 	`json:"pollId"`
-	UserId    string    `json:"userId"`
+	PollId string `json:"pollId"`
+	UserId string `json:"userId"`
+}
+
+type QuarantinedVotes struct {
+	PollId   string `json:"pollId"`
+	Page     int64  `json:"page"`
+	PageSize int64  `json:"pageSize"`
+}
+
+type ReviewVotes struct {
+	PollId  string   `json:"pollId"`
+	VoteIds []string `json:"voteIds"`
+	// Accept counts the votes, otherwise they are rejected for good
+	Accept bool `json:"accept"`
+}
+
+type InvitedVoter struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type InviteVoters struct {
+	PollId string         `json:"pollId"`
+	Voters []InvitedVoter `json:"voters"`
+	// Delivery is either "email" to send every voter their link, or "csv" to get them back as a CSV file
+	Delivery string `json:"delivery"`
+}
+
+type PollInvitations struct {
+	PollId string `json:"pollId"`
+}
+
+type UnlockPoll struct {
+	PollId     string `json:"pollId"`
+	Passphrase string `json:"passphrase"`
+}
+
+type PollVoters struct {
+	PollId   string `json:"pollId"`
+	Choice   uint   `json:"choice"`
+	Page     int64  `json:"page"`
+	PageSize int64  `json:"pageSize"`
+	Grant    string `json:"grant"`
+}
+
+type CheckReceipt struct {
+	PollId   string `json:"pollId"`
+	BallotId string `json:"ballotId"`
+	Hash     string `json:"hash"`
+	Grant    string `json:"grant"`
+}
+
+type VerifyCertificate struct {
+	Certificate certificate.Certificate `json:"certificate"`
+}
+
+type SubmitDecryption struct {
+	PollId  string                    `json:"pollId"`
+	Partial elgamal.PartialDecryption `json:"partial"`
 }
@@ -24,3 +24,53 @@ type Logout struct {
 type RefreshToken struct {
 	RefreshToken 	string `json:"refreshToken"`
 }
+
+type OIDCCallback struct {
+	Code  			string `json:"code"`
+	State 			string `json:"state"`
+}
+
+type TOTPVerify struct {
+	Code 			string `json:"code"`
+}
+
+type TOTPDisable struct {
+	Password 		string `json:"password"`
+	Code 			string `json:"code"`
+	RecoveryCode 	string `json:"recoveryCode"`
+}
+
+type Reactivate struct {
+	ReactivationToken 	string `json:"reactivationToken"`
+}
+
+type TOTPLogin struct {
+	ChallengeToken 	string `json:"challengeToken"`
+	Code 			string `json:"code"`
+	RecoveryCode 	string `json:"recoveryCode"`
+}
+
+type CreateAPIToken struct {
+	Name 			string   `json:"name"`
+	Scopes 			[]string `json:"scopes"`
+	ExpiresInDays 	int      `json:"expiresInDays"`
+}
+
+type RevokeAPIToken struct {
+	TokenId 		string `json:"tokenId"`
+}
+
+type Deactivate struct {
+	Polls 			string `json:"polls"`
+	TransferTo 		string `json:"transferTo"`
+	KeepVotes 		*bool  `json:"keepVotes"`
+}
+
+type ExportStatus struct {
+	ExportId 		string `json:"exportId"`
+}
+
+type UpdateProfile struct {
+	DisplayName 	string `json:"displayName"`
+	Bio 			string `json:"bio"`
+}
@@ -1,6 +1,10 @@
 package responses
 
-import "github.com/gin-gonic/gin"
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
 
 type APIResponse struct {
 	Status   int    `json:"status"`
@@ -16,3 +20,16 @@ func Send(c *gin.Context, status int, message string, metadata gin.H) {
 	}
 	c.JSON(status, response)
 }
+
+// FieldError describes why a single field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SendFieldErrors rejects a request with HTTP 400 and the list of field errors
+func SendFieldErrors(c *gin.Context, message string, fieldErrors []FieldError) {
+	Send(c, http.StatusBadRequest, message, gin.H{
+		"errors": fieldErrors,
+	})
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"os"
+	"strconv"
+)
+
+// EnvInt reads a setting from the environment, falling back when it is unset, malformed
+// or negative
+func EnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// EnvBool reads a setting from the environment, falling back when it is unset or malformed
+func EnvBool(name string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
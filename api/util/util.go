@@ -1,13 +1,32 @@
 package util
 
-import "math/rand"
+import (
+	"crypto/rand"
+	"math/big"
+	mathrand "math/rand"
+)
 
 var chars = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 func GenRandomString(n uint) string {
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = chars[rand.Intn(len(chars))]
+		b[i] = chars[mathrand.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// GenSecureRandomString is like GenRandomString, but draws from crypto/rand so the
+// result can be used as a secret (tokens, nonces, PKCE verifiers, ...)
+func GenSecureRandomString(n uint) string {
+	b := make([]rune, n)
+	max := big.NewInt(int64(len(chars)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = chars[idx.Int64()]
 	}
 	return string(b)
 }
@@ -20,7 +20,7 @@ const voteEndPoint = getEndpointURL('/api/polls/vote');
 const closeEndPoint = getEndpointURL('/api/polls/close');
 
 function fetchPoll(pollId, data) {
-    return axios.post(viewEndPoint + '/' + pollId, data)
+    return axios.post(viewEndPoint + '/' + pollId, data, { withCredentials: true })
         .then(response => [response.data.metadata, true])
         .catch(error => [error.response.data, false]);
 }
@@ -32,7 +32,7 @@ function castVote(data) {
 }
 
 function closePoll(data) {
-    return axios.post(closeEndPoint, data, { withCredentials: false })
+    return axios.post(closeEndPoint, data, { withCredentials: true })
         .then(response => [response.data.metadata, true])
         .catch(error => [error.response, false]);
 }
@@ -46,7 +46,7 @@ function VotePoll() {
     const [ isOpen, setIsOpen ] = useState(false);
     const [ isOwner, setIsOwner ] = useState(false);
     const [ showPollCloseConfirm, setShowPollCloseConfirm] = useState(false);
-    const [ pastVote, setPastVote ] = useState();
+    const [ voted, setVoted ] = useState(false);
     const [ notification, setNotification ] = useState();
     const navigate = useNavigate();
     const params = useParams();
@@ -69,7 +69,7 @@ function VotePoll() {
                     setPoll(response.poll);
                     setCanVote(response.canVote);
                     setIsOpen(response.poll.Status);
-                    setPastVote(response.pastVote);
+                    setVoted(response.voted);
                     setLoading(false);
                     if(session && response.poll.Creator === session.userId){
                         setIsOwner(true);
@@ -225,16 +225,8 @@ function VotePoll() {
                                         return (<Button 
                                             className="optionButton"
                                             key={key} 
-                                            variant={
-                                                (pastVote && pastVote.PollId)
-                                                    ? (pastVote.Choice === key ? "success" : "dark")
-                                                    : (pollChoice === key ? "success" : "dark")
-                                            }
-                                            active={
-                                                (pastVote && pastVote.PollId)
-                                                    ? pastVote.Choice === key
-                                                    : pollChoice === key
-                                            }
+                                            variant={!voted && pollChoice === key ? "success" : "dark"}
+                                            active={!voted && pollChoice === key}
                                             onClick={() => setPollChoice(key)}
                                             disabled={!canVote || !isOpen}
                                         >